@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/dgraph-io/badger"
+)
+
+var addrPrefix = []byte("addr-")
+
+// HistoryEntry sums up what a transaction of the main chain did to the
+// balance of an address: what its outputs paid to the address and what
+// its inputs spent from it
+type HistoryEntry struct {
+	TxID     []byte
+	Height   int
+	Received int
+	Sent     int
+}
+
+// addrKey builds the database key of an address index entry: prefix,
+// public key hash, block height, position of the transaction in the block
+// and position of the input or output in the transaction, so that the
+// entries of an address are sorted chronologically
+func addrKey(pubKeyHash []byte, height, txPos, entryPos int) []byte {
+	return bytes.Join([][]byte{
+		addrPrefix,
+		pubKeyHash,
+		IntToHex(int64(height)),
+		IntToHex(int64(txPos)),
+		IntToHex(int64(entryPos)),
+	}, []byte{})
+}
+
+// forEachAddressEntry calls fn with the key and value of the address index
+// entry of every input and output of a block. The value holds the amount,
+// negative for inputs, followed by the transaction ID. spent holds the
+// outputs spent by the block's inputs in order, as kept in its undo data.
+// Outputs that don't pay to a public key hash belong to no address
+func forEachAddressEntry(block *Block, spent []UnspentOutput, fn func(key, value []byte) error) error {
+	for txPos, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for inIdx := range tx.Inputs {
+				out := spent[0]
+				spent = spent[1:]
+
+				pubKeyHash := out.PubKeyHash()
+				if pubKeyHash == nil {
+					continue
+				}
+				key := addrKey(pubKeyHash, block.Height, txPos, inIdx)
+				if err := fn(key, append(IntToHex(int64(-out.Value)), tx.ID...)); err != nil {
+					return err
+				}
+			}
+		}
+
+		for outIdx, out := range tx.Outputs {
+			pubKeyHash := out.PubKeyHash()
+			if pubKeyHash == nil {
+				continue
+			}
+			key := addrKey(pubKeyHash, block.Height, txPos, len(tx.Inputs)+outIdx)
+			if err := fn(key, append(IntToHex(int64(out.Value)), tx.ID...)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// spentOutputs reads the undo data of a connected block
+func spentOutputs(txn *badger.Txn, block *Block) ([]UnspentOutput, error) {
+	item, err := txn.Get(undoKey(block.Hash))
+	if err != nil {
+		return nil, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return deserializeUndo(v)
+}
+
+// indexAddresses adds the inputs and outputs of a connected block to the
+// address index, as part of the caller's database transaction. It must
+// run after updateUTXO, which records the outputs the block spends
+func indexAddresses(txn *badger.Txn, block *Block) error {
+	spent, err := spentOutputs(txn, block)
+	if err != nil {
+		return err
+	}
+
+	return forEachAddressEntry(block, spent, txn.Set)
+}
+
+// unindexAddresses drops the inputs and outputs of a disconnected block
+// from the address index, as part of the caller's database transaction.
+// It must run before revertUTXO, which removes the block's undo data
+func unindexAddresses(txn *badger.Txn, block *Block) error {
+	spent, err := spentOutputs(txn, block)
+	if err != nil {
+		return err
+	}
+
+	return forEachAddressEntry(block, spent, func(key, value []byte) error {
+		return txn.Delete(key)
+	})
+}
+
+// History returns the transactions of the main chain that paid to or
+// spent from the address with the given public key hash, oldest first
+func (bc *Blockchain) History(pubKeyHash []byte) ([]HistoryEntry, error) {
+	var history []HistoryEntry
+	prefix := append(append([]byte{}, addrPrefix...), pubKeyHash...)
+
+	err := bc.DB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().Key()
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			height := int(binary.BigEndian.Uint64(key[len(prefix):]))
+			amount := int(int64(binary.BigEndian.Uint64(value[:8])))
+			txID := value[8:]
+
+			last := len(history) - 1
+			if last < 0 || history[last].Height != height || bytes.Compare(history[last].TxID, txID) != 0 {
+				history = append(history, HistoryEntry{TxID: txID, Height: height})
+				last++
+			}
+			if amount < 0 {
+				history[last].Sent -= amount
+			} else {
+				history[last].Received += amount
+			}
+		}
+
+		return nil
+	})
+
+	return history, err
+}
+
+// ReindexAddresses rebuilds the address index from the main chain and the
+// undo data of its blocks
+func (bc *Blockchain) ReindexAddresses() error {
+	db := bc.DB
+
+	if err := (UTXOSet{bc}).deleteByPrefix(addrPrefix); err != nil {
+		return err
+	}
+
+	txn := db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+	writes := 0
+
+	iter := bc.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		if writes >= utxoBatchSize {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = db.NewTransaction(true)
+			writes = 0
+		}
+
+		spent, err := spentOutputs(txn, block)
+		if err != nil {
+			return err
+		}
+		err = forEachAddressEntry(block, spent, func(key, value []byte) error {
+			writes++
+			return txn.Set(key, value)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(block.HashPrevBlock) == 0 {
+			break
+		}
+	}
+
+	return txn.Commit()
+}
@@ -2,73 +2,166 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
-	"encoding/gob"
-	"log"
+	"errors"
+	"golang-blockchain/merkle"
 	"time"
 )
 
-// Block represents a block
-type Block struct {
-	Time          int64
-	Hash          []byte
+// BlockHeader holds the fields of a block that its hash commits to. The
+// transactions are covered by the Merkle root, so a header can be stored,
+// sent and validated on its own
+type BlockHeader struct {
+	Version       int
 	HashPrevBlock []byte
-	Transactions  []*Transaction
+	MerkleRoot    []byte
+	Time          int64
+	Bits          int
 	Nonce         int
+	Height        int
+}
+
+// BlockHash returns the hash of the header, which is the hash of the block
+func (h *BlockHeader) BlockHash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+
+	return hash[:]
+}
+
+// Serialize returns the canonical encoding of a header
+func (h *BlockHeader) Serialize() []byte {
+	var e encoder
+	e.header(h)
+
+	return e.buf.Bytes()
 }
 
-// NewBlock is used to crerate a new block
-func NewBlock(txs []*Transaction, hashPrevBlock []byte) *Block {
-	time := time.Now().Unix()
+// DeserializeHeader decodes a header, it returns ErrMalformedData or
+// ErrUnknownVersion for data that is not a canonical encoding
+func DeserializeHeader(data []byte) (*BlockHeader, error) {
+	d := decoder{data: data}
+	header := d.header()
+
+	return header, d.finish()
+}
+
+// Block represents a block: its header, the transactions and the hash of
+// the header
+type Block struct {
+	BlockHeader
+	Hash         []byte
+	Transactions []*Transaction
+}
+
+// newBlock returns a block that is not mined yet
+func newBlock(txs []*Transaction, hashPrevBlock []byte, height, bits int) *Block {
 	block := &Block{
-		Transactions:  txs,
-		HashPrevBlock: hashPrevBlock,
-		Hash:          []byte{},
-		Time:          time,
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			HashPrevBlock: hashPrevBlock,
+			Time:          time.Now().Unix(),
+			Bits:          bits,
+			Height:        height,
+		},
+		Transactions: txs,
 	}
-	pow := NewProofOfWork(block)
-	nonce, hash := pow.Run()
-	block.Nonce = nonce
-	block.Hash = hash
+	block.MerkleRoot = block.HashTransactions()
+
 	return block
 }
 
-// HashTransactions returns a hash of the transactions in the block
+// NewBlock is used to crerate a new block, the first transaction must be
+// the coinbase. It returns ctx.Err() when mining is cancelled
+func NewBlock(ctx context.Context, txs []*Transaction, hashPrevBlock []byte, height, bits int, opts MiningOptions) (*Block, error) {
+	block := newBlock(txs, hashPrevBlock, height, bits)
+	if err := block.Mine(ctx, opts); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// Mine searches for the proof of work of the block and sets its nonce and
+// hash. When no nonce fits, an extra nonce is written into the data of the
+// coinbase, which changes the Merkle root, and the search starts over. It
+// returns ctx.Err() when mining is cancelled
+func (b *Block) Mine(ctx context.Context, opts MiningOptions) error {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return errors.New("first transaction of the block must be a coinbase")
+	}
+	coinbase := b.Transactions[0]
+	data := coinbase.Inputs[0].ScriptSig
+
+	for extraNonce := 1; ; extraNonce++ {
+		nonce, hash, err := NewProofOfWork(&b.BlockHeader).Run(ctx, opts)
+		if err == errNonceExhausted {
+			coinbase.Inputs[0].ScriptSig = append(append([]byte{}, data...), IntToHex(int64(extraNonce))...)
+			coinbase.SetID()
+			b.MerkleRoot = b.HashTransactions()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		b.Nonce = nonce
+		b.Hash = hash
+		return nil
+	}
+}
+
+// HashTransactions returns the Merkle root of the transactions in the block
 func (b *Block) HashTransactions() []byte {
-	var txHashes [][]byte
-	var txHash [32]byte
+	return b.merkleTree().Root()
+}
+
+// MerkleProof returns the transaction with the given ID together with the
+// proof of its inclusion in the block
+func (b *Block) MerkleProof(txID []byte) (*Transaction, *merkle.Proof, error) {
+	for i, tx := range b.Transactions {
+		if bytes.Compare(tx.ID, txID) == 0 {
+			proof, err := b.merkleTree().Proof(i)
+			return tx, proof, err
+		}
+	}
+
+	return nil, nil, ErrTxNotFound
+}
+
+func (b *Block) merkleTree() *merkle.Tree {
+	var txs [][]byte
 
 	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.ID)
+		txs = append(txs, tx.Serialize())
 	}
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
 
-	return txHash[:]
+	return merkle.NewTree(txs)
 }
 
 // Genesis creates a genesis block
-func Genesis(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{})
+func Genesis(coinbase *Transaction) (*Block, error) {
+	return NewBlock(context.Background(), []*Transaction{coinbase}, []byte{}, 0, initialBits, MiningOptions{})
 }
 
-// Serialize serializes a block
+// Serialize returns the canonical encoding of a block
 func (b *Block) Serialize() []byte {
-	var res bytes.Buffer
-	encoder := gob.NewEncoder(&res)
-	err := encoder.Encode(b)
-	if err != nil {
-		log.Panic(err)
-	}
-	return res.Bytes()
+	var e encoder
+	e.block(b)
+
+	return e.buf.Bytes()
 }
 
-// Deserialize deserializes a block
-func Deserialize(data []byte) *Block {
-	var block Block
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&block)
-	if err != nil {
-		log.Panic(err)
+// Deserialize decodes a block, it returns ErrMalformedData or
+// ErrUnknownVersion for data that is not a canonical encoding. The hash
+// of the block is computed from its header
+func Deserialize(data []byte) (*Block, error) {
+	d := decoder{data: data}
+	block := d.block()
+	if err := d.finish(); err != nil {
+		return nil, err
 	}
-	return &block
+	block.Hash = block.BlockHash()
+
+	return block, nil
 }
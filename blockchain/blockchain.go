@@ -1,151 +1,224 @@
 package blockchain
 
 import (
-	"bytes"
+	"context"
 	"crypto/ecdsa"
+	"encoding/binary"
 	"encoding/hex"
-	"errors"
 	"fmt"
-	"log"
+	"golang-blockchain/wallet"
+	"math/big"
 	"os"
-	"runtime"
+	"path/filepath"
 
 	"github.com/dgraph-io/badger"
 )
 
 const (
-	dbPath      = "./tmp/blocks"
-	dbFile      = "./tmp/blocks/MANIFEST"
+	blocksDir   = "blocks"
 	genesisData = "First transactions from Genesis"
 )
 
-// Blockchain represents a blockchain
+// maturityKey holds the coinbase maturity the chain was created with
+var maturityKey = []byte("maturity")
+
+// Blockchain represents a blockchain. All its state lives in the database
+// under its data directory, so several chains with different directories
+// can be open in the same process
 type Blockchain struct {
 	LastHash []byte
 	DB       *badger.DB
+	// CoinbaseMaturity is the number of blocks that must follow the block
+	// of a coinbase before its outputs can be spent. It is chosen when the
+	// chain is created and every node of a network must use the same value,
+	// or they won't agree on which blocks are valid
+	CoinbaseMaturity int
+}
+
+// dbDir returns the database directory inside a data directory
+func dbDir(dataDir string) string {
+	return filepath.Join(dataDir, blocksDir)
 }
 
 // DBexists checks db and if db exists returns true else false
-func DBexists() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+func DBexists(path string) bool {
+	if _, err := os.Stat(filepath.Join(path, "MANIFEST")); os.IsNotExist(err) {
 		return false
 	}
 	return true
 }
 
-// InitBlockchain is used to init blockchain
-func InitBlockchain(address string) *Blockchain {
-	var lastHash []byte
-
-	if DBexists() {
-		fmt.Println("Blockchain already exists")
-		runtime.Goexit()
+func openDB(path string) (*badger.DB, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
 	}
 
 	opts := badger.DefaultOptions
-	opts.Dir = dbPath
-	opts.ValueDir = dbPath
+	opts.Dir = path
+	opts.ValueDir = path
+
+	return badger.Open(opts)
+}
+
+// InitBlockchain creates a new blockchain in dataDir whose genesis block
+// pays the given address, with the given coinbase maturity. It returns
+// ErrChainExists when there already is one
+func InitBlockchain(address, dataDir string, maturity int) (*Blockchain, error) {
+	var lastHash []byte
+	path := dbDir(dataDir)
+
+	if !wallet.ValidateAddress(address) {
+		return nil, ErrInvalidAddress
+	}
+	if maturity < 0 {
+		return nil, fmt.Errorf("the coinbase maturity can't be negative")
+	}
+	if DBexists(path) {
+		return nil, ErrChainExists
+	}
 
-	db, err := badger.Open(opts)
+	db, err := openDB(path)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinbaseTX(address, genesisData)
-		genesis := Genesis(cbtx)
-		fmt.Println("Genesis created")
-		err := txn.Set(genesis.Hash, genesis.Serialize())
+		cbtx, err := CoinbaseTX(address, genesisData, 0, 0)
 		if err != nil {
 			return err
 		}
-
-		err = txn.Set([]byte("lh"), genesis.Hash)
+		genesis, err := Genesis(cbtx)
 		if err != nil {
 			return err
 		}
+		fmt.Println("Genesis created")
 
 		lastHash = genesis.Hash
 
-		return err
-	})
+		err = txn.Set(maturityKey, IntToHex(int64(maturity)))
+		if err != nil {
+			return err
+		}
+		err = saveBlock(txn, genesis, blockWork(genesis.Bits))
+		if err != nil {
+			return err
+		}
 
+		return connectBlock(txn, genesis)
+	})
 	if err != nil {
-		log.Panic(err)
+		db.Close()
+		return nil, err
 	}
 
-	blockchain := Blockchain{lastHash, db}
-	return &blockchain
+	blockchain := Blockchain{lastHash, db, maturity}
+	return &blockchain, nil
 }
 
-// ContinueBlockchain ...
-func ContinueBlockchain(address string) *Blockchain {
+// ContinueBlockchain opens the blockchain in dataDir with the coinbase
+// maturity it was created with. It returns ErrChainNotFound when there is
+// none, and ErrChainFormat when its database was written by an older
+// version
+func ContinueBlockchain(dataDir string) (*Blockchain, error) {
 	var lastHash []byte
+	var maturity int
+	path := dbDir(dataDir)
 
-	if DBexists() == false {
-		fmt.Println("No existing blockchain found")
-		runtime.Goexit()
+	if DBexists(path) == false {
+		return nil, ErrChainNotFound
 	}
 
-	opts := badger.DefaultOptions
-	opts.Dir = dbPath
-	opts.ValueDir = dbPath
-
-	db, err := badger.Open(opts)
+	db, err := openDB(path)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	err = db.Update(func(txn *badger.Txn) error {
+	err = db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("lh"))
-		item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
+		if err != nil {
+			return err
+		}
+		lastHash, err = item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
 
-		return err
-	})
+		// older versions stored whole gob encoded blocks under their hash
+		// and no headers, such a tip can't be read
+		_, err = getHeader(txn, lastHash)
+		switch err {
+		case ErrBlockNotFound, ErrMalformedData, ErrUnknownVersion:
+			return ErrChainFormat
+		}
+		if err != nil {
+			return err
+		}
 
+		// and the maturity was a setting of each command, which may not
+		// match the one the chain was built with
+		item, err = txn.Get(maturityKey)
+		if err == badger.ErrKeyNotFound {
+			return ErrChainFormat
+		}
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if len(value) != 8 {
+			return ErrMalformedData
+		}
+		maturity = int(binary.BigEndian.Uint64(value))
+
+		return nil
+	})
 	if err != nil {
-		log.Panic(err)
+		db.Close()
+		return nil, err
 	}
 
-	blockchain := Blockchain{DB: db, LastHash: lastHash}
-	return &blockchain
+	blockchain := Blockchain{DB: db, LastHash: lastHash, CoinbaseMaturity: maturity}
+	return &blockchain, nil
 }
 
-// FindUnspentTransactions is used to find all unspent transaction
-func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
-	var unspentTXs []Transaction
+// FindUTXO scans the whole chain and returns every unspent transaction
+// output, grouped by transaction ID and keyed by output index
+func (bc *Blockchain) FindUTXO() (map[string]map[int]UnspentOutput, error) {
+	UTXO := make(map[string]map[int]UnspentOutput)
 	spentTXOs := make(map[string][]int)
 
 	iter := bc.Iterator()
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 
-		for _, tx := range block.Transactions {
+		// transactions of a block may spend outputs of earlier ones in
+		// the same block, so walk them backwards as well
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			tx := block.Transactions[i]
 			txID := hex.EncodeToString(tx.ID)
 
 		Outputs:
 			for outIdx, out := range tx.Outputs {
-				if spentTXOs[txID] != nil {
-					for _, spentOut := range spentTXOs[txID] {
-						if spentOut == outIdx {
-							continue Outputs
-						}
+				for _, spentOut := range spentTXOs[txID] {
+					if spentOut == outIdx {
+						continue Outputs
 					}
 				}
-				if out.IsLockedWithKey(pubKeyHash) {
-					unspentTXs = append(unspentTXs, *tx)
+				if UTXO[txID] == nil {
+					UTXO[txID] = make(map[int]UnspentOutput)
 				}
+				UTXO[txID][outIdx] = UnspentOutput{out, block.Height, tx.IsCoinbase()}
 			}
 
 			if tx.IsCoinbase() == false {
 				for _, in := range tx.Inputs {
-					if in.UsesKey(pubKeyHash) {
-						inTxID := hex.EncodeToString(in.ID)
-						spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
-					}
+					inTxID := hex.EncodeToString(in.ID)
+					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
 				}
 			}
 		}
@@ -155,140 +228,333 @@ func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
 		}
 	}
 
-	return unspentTXs
+	return UTXO, nil
 }
 
-// FindUTXO is used to find all unspent transaction outputs
-func (bc *Blockchain) FindUTXO(pubKeyHash []byte) []TXOutput {
-	var UTXOs []TXOutput
-	unspentTXs := bc.FindUnspentTransactions(pubKeyHash)
+// saveBlock stores the header and the transactions of a block apart,
+// together with the total work of the chain it ends, as part of the
+// caller's database transaction
+func saveBlock(txn *badger.Txn, block *Block, work *big.Int) error {
+	err := txn.Set(headerKey(block.Hash), block.BlockHeader.Serialize())
+	if err != nil {
+		return err
+	}
 
-	for _, tx := range unspentTXs {
-		for _, out := range tx.Outputs {
-			if out.IsLockedWithKey(pubKeyHash) {
-				UTXOs = append(UTXOs, out)
-			}
-		}
+	var body encoder
+	body.transactions(block.Transactions)
+	err = txn.Set(block.Hash, body.buf.Bytes())
+	if err != nil {
+		return err
 	}
 
-	return UTXOs
+	return txn.Set(workKey(block.Hash), work.Bytes())
 }
 
-// FindSpendableOutputs finds and returns unspent outputs to reference in inputs
-func (bc *Blockchain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOuts := make(map[string][]int)
-	unspentTXs := bc.FindUnspentTransactions(pubKeyHash)
-	accumulated := 0
-
-Work:
-	for _, tx := range unspentTXs {
-		txID := hex.EncodeToString(tx.ID)
-		for outIdx, out := range tx.Outputs {
-			if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-				accumulated += out.Value
-				unspentOuts[txID] = append(unspentOuts[txID], outIdx)
-
-				if accumulated >= amount {
-					break Work
-				}
-			}
-		}
+// connectBlock makes a stored block the new tip of the chain, indexes its
+// height and transactions, applies it to the UTXO set, indexes the
+// addresses it touches and drops its transactions from the mempool, as
+// part of the caller's database transaction
+func connectBlock(txn *badger.Txn, block *Block) error {
+	err := txn.Set([]byte("lh"), block.Hash)
+	if err != nil {
+		return err
+	}
+
+	err = txn.Set(heightKey(block.Height), block.Hash)
+	if err != nil {
+		return err
+	}
+
+	err = indexTransactions(txn, block)
+	if err != nil {
+		return err
+	}
+
+	err = updateUTXO(txn, block)
+	if err != nil {
+		return err
 	}
 
-	return accumulated, unspentOuts
+	err = indexAddresses(txn, block)
+	if err != nil {
+		return err
+	}
+
+	return removeMinedFromMempool(txn, block)
 }
 
-// AddBlock adds a new block to blockchain
-func (bc *Blockchain) AddBlock(transactions []*Transaction) {
-	var lastHash []byte
+// disconnectBlock makes the parent of the tip the new tip of the chain,
+// drops the tip from the height, transaction and address indexes, reverts
+// its changes to the UTXO set and puts its transactions back into the
+// mempool, as part of the caller's database transaction
+func disconnectBlock(txn *badger.Txn, block *Block) error {
+	err := txn.Set([]byte("lh"), block.HashPrevBlock)
+	if err != nil {
+		return err
+	}
 
-	err := bc.DB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		if err != nil {
-			return err
-		}
-		item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
+	err = txn.Delete(heightKey(block.Height))
+	if err != nil {
+		return err
+	}
 
+	err = unindexTransactions(txn, block)
+	if err != nil {
 		return err
-	})
+	}
+
+	err = unindexAddresses(txn, block)
+	if err != nil {
+		return err
+	}
+
+	err = revertUTXO(txn, block)
+	if err != nil {
+		return err
+	}
+
+	return returnToMempool(txn, block)
+}
+
+// blockTemplate returns a block with the provided transactions on top of
+// the chain, it still has to be mined
+func (bc *Blockchain) blockTemplate(transactions []*Transaction) (*Block, error) {
+	lastHeader, err := bc.GetHeader(bc.LastHash)
+	if err != nil {
+		return nil, err
+	}
+
+	bits, err := bc.NextBits(&lastHeader)
+	if err != nil {
+		return nil, err
+	}
+	medianTime, err := bc.MedianTimePast(&lastHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	block := newBlock(transactions, bc.LastHash, lastHeader.Height+1, bits)
+	if block.Time <= medianTime {
+		// blocks mined within the same second need a later timestamp
+		block.Time = medianTime + 1
+	}
+
+	return block, nil
+}
+
+// MineBlock mines a new block with the provided transactions on top of
+// the chain and stores it. It returns ctx.Err() when mining is cancelled
+func (bc *Blockchain) MineBlock(ctx context.Context, transactions []*Transaction, opts MiningOptions) (*Block, error) {
+	newBlock, err := bc.blockTemplate(transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = newBlock.Mine(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = bc.AddBlock(newBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
+
+// AddBlock validates and stores a block received from another node or
+// mined locally. Blocks of side branches are stored as well, and the
+// chain is reorganized when a branch gets more accumulated work than the
+// current one. It reports whether the block was stored: known blocks and
+// blocks whose parent is unknown are ignored. Blocks breaking a consensus
+// rule are rejected with a *BlockValidationError
+func (bc *Blockchain) AddBlock(block *Block) (bool, error) {
+	known, err := bc.HasBlock(block.Hash)
+	if err != nil || known {
+		return false, err
+	}
+	prev, err := bc.GetHeader(block.HashPrevBlock)
+	if err == ErrBlockNotFound {
+		return false, nil
+	}
 	if err != nil {
-		log.Panic(err)
+		return false, err
 	}
 
-	newBlock := NewBlock(transactions, lastHash)
+	bits, err := bc.NextBits(&prev)
+	if err != nil {
+		return false, err
+	}
+	medianTime, err := bc.MedianTimePast(&prev)
+	if err != nil {
+		return false, err
+	}
+	err = validateHeader(&block.BlockHeader, &prev, bits, medianTime)
+	if err != nil {
+		return false, err
+	}
+	err = validateMerkleRoot(block)
+	if err != nil {
+		return false, err
+	}
 
+	newTip := false
 	err = bc.DB.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
+		prevWork, err := chainWork(txn, block.HashPrevBlock)
+		if err != nil {
+			return err
+		}
+		tipWork, err := chainWork(txn, bc.LastHash)
 		if err != nil {
 			return err
 		}
-		err = txn.Set([]byte("lh"), newBlock.Hash)
 
-		bc.LastHash = newBlock.Hash
+		work := new(big.Int).Add(prevWork, blockWork(block.Bits))
+		err = saveBlock(txn, block, work)
+		if err != nil {
+			return err
+		}
+
+		if work.Cmp(tipWork) <= 0 {
+			return nil
+		}
+
+		newTip = true
+		return bc.reorganize(txn, block)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if newTip {
+		bc.LastHash = block.Hash
+	}
+
+	return true, nil
+}
 
+// HasBlock checks whether a block with the given hash is stored
+func (bc *Blockchain) HasBlock(blockHash []byte) (bool, error) {
+	err := bc.DB.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(headerKey(blockHash))
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// GetBlock returns the block with the given hash, or ErrBlockNotFound
+func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
+	var block *Block
+
+	err := bc.DB.View(func(txn *badger.Txn) error {
+		var err error
+		block, err = getBlock(txn, blockHash)
 		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return Block{}, err
 	}
+
+	return *block, nil
 }
 
-// Iterator creates a new blockchain iterator
-func (bc *Blockchain) Iterator() *Iterator {
-	iter := &Iterator{bc.LastHash, bc.DB}
+// GetBestHeight returns the height of the tip of the chain
+func (bc *Blockchain) GetBestHeight() (int, error) {
+	lastHeader, err := bc.GetHeader(bc.LastHash)
+	if err != nil {
+		return 0, err
+	}
 
-	return iter
+	return lastHeader.Height, nil
 }
 
-// FindTransaction is used to find transaction by provided id
-func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
-	iter := bc.Iterator()
+// GetBlockHashes returns the hashes of all blocks in the chain,
+// starting from the tip. Only the headers are read
+func (bc *Blockchain) GetBlockHashes() ([][]byte, error) {
+	var blocks [][]byte
 
+	iter := bc.Iterator()
 	for {
-		block := iter.Next()
-
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
+		blockHash := iter.currentHash
+		header, err := iter.NextHeader()
+		if err != nil {
+			return nil, err
 		}
 
-		if len(block.HashPrevBlock) == 0 {
+		blocks = append(blocks, blockHash)
+
+		if len(header.HashPrevBlock) == 0 {
 			break
 		}
 	}
 
-	return Transaction{}, errors.New("Transaction does not exist")
+	return blocks, nil
 }
 
-// SignTransaction is used to sign transaction
-func (bc *Blockchain) SignTransaction(tx *Transaction, privateKey ecdsa.PrivateKey) {
+// Iterator creates a new blockchain iterator
+func (bc *Blockchain) Iterator() *Iterator {
+	iter := &Iterator{bc.LastHash, bc.DB}
+
+	return iter
+}
+
+// FindTransaction is used to find transaction by provided id, it returns
+// ErrTxNotFound when it is not in the chain
+func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+	tx, _, err := bc.LocateTransaction(ID)
+
+	return tx, err
+}
+
+// previousTransactions returns the outputs spent by the inputs of tx,
+// looked up in the UTXO set, in place of the transactions creating them.
+// It returns ErrTxNotFound when one of them is spent or doesn't exist
+func (bc *Blockchain) previousTransactions(tx *Transaction) (map[string]Transaction, error) {
 	prevTXs := make(map[string]Transaction)
+	UTXO := UTXOSet{bc}
 
 	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
+		out, ok, err := UTXO.FindOutput(in.ID, in.Out)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrTxNotFound
 		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+		addPrevOutput(prevTXs, in.ID, in.Out, out.TXOutput)
 	}
 
-	tx.Sign(privateKey, prevTXs)
+	return prevTXs, nil
 }
 
-// VerifyTransaction is used to verify transaction
-func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
-	prevTXs := make(map[string]Transaction)
+// SignTransaction is used to sign transaction, it returns ErrTxNotFound
+// when an output it spends is not in the UTXO set
+func (bc *Blockchain) SignTransaction(tx *Transaction, privateKey ecdsa.PrivateKey) error {
+	prevTXs, err := bc.previousTransactions(tx)
+	if err != nil {
+		return err
+	}
 
-	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	return tx.Sign(privateKey, prevTXs)
+}
+
+// VerifyTransaction is used to verify transaction, it returns
+// ErrInvalidSignature when the script of an input doesn't unlock the
+// output it spends and ErrTxNotFound when that output is not in the UTXO
+// set
+func (bc *Blockchain) VerifyTransaction(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	prevTXs, err := bc.previousTransactions(tx)
+	if err != nil {
+		return err
 	}
 
 	return tx.Verify(prevTXs)
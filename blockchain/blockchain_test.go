@@ -0,0 +1,57 @@
+package blockchain
+
+import (
+	"context"
+	"golang-blockchain/wallet"
+	"testing"
+)
+
+// newTestChain creates a chain in a temporary data directory whose genesis
+// coinbase pays a new wallet
+func newTestChain(t *testing.T) (*Blockchain, *wallet.Wallet) {
+	t.Helper()
+
+	return newMaturityChain(t, DefaultCoinbaseMaturity)
+}
+
+// newMaturityChain is newTestChain with the given coinbase maturity
+func newMaturityChain(t *testing.T, maturity int) (*Blockchain, *wallet.Wallet) {
+	t.Helper()
+
+	w, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := InitBlockchain(string(w.Address()), t.TempDir(), maturity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.DB.Close() })
+
+	return bc, w
+}
+
+// mineBlocks mines n blocks of the pending transactions, the coinbases
+// paying w
+func mineBlocks(t *testing.T, bc *Blockchain, w *wallet.Wallet, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		_, err := Mempool{bc}.Mine(context.Background(), string(w.Address()), MiningOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// balance returns the spendable and immature balance of a wallet
+func balance(t *testing.T, bc *Blockchain, w *wallet.Wallet) (int, int) {
+	t.Helper()
+
+	spendable, immature, err := UTXOSet{bc}.Balance(wallet.PublicKeyHash(w.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return spendable, immature
+}
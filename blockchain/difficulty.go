@@ -0,0 +1,66 @@
+package blockchain
+
+const (
+	// initialBits is the difficulty of the genesis block, the number of
+	// leading zero bits a block hash must have
+	initialBits = 15
+	// minBits and maxBits bound the difficulty
+	minBits = 8
+	maxBits = 64
+	// retargetInterval is the number of blocks between difficulty adjustments
+	retargetInterval = 10
+	// targetBlockTime is the number of seconds a block should take to mine
+	targetBlockTime = 10
+	// maxRetargetStep limits how many bits a single adjustment may change
+	maxRetargetStep = 2
+)
+
+// NextBits returns the difficulty of the block following prev. It stays
+// the same within a retarget interval, at every interval boundary it is
+// recomputed from how long the last interval actually took. Only headers
+// are read
+func (bc *Blockchain) NextBits(prev *BlockHeader) (int, error) {
+	height := prev.Height + 1
+	if height%retargetInterval != 0 {
+		return prev.Bits, nil
+	}
+
+	first := prev
+	for first.Height > 0 && prev.Height-first.Height < retargetInterval {
+		header, err := bc.GetHeader(first.HashPrevBlock)
+		if err != nil {
+			return 0, err
+		}
+		first = &header
+	}
+
+	actual := prev.Time - first.Time
+	expected := int64(prev.Height-first.Height) * targetBlockTime
+
+	return retarget(prev.Bits, actual, expected), nil
+}
+
+// retarget adjusts bits by one for every factor of two between the
+// actual and the expected time of an interval
+func retarget(bits int, actual, expected int64) int {
+	if actual < 1 {
+		actual = 1
+	}
+
+	for i := 0; i < maxRetargetStep && actual*2 <= expected; i++ {
+		bits++
+		actual *= 2
+	}
+	for i := 0; i < maxRetargetStep && actual >= expected*2; i++ {
+		bits--
+		actual /= 2
+	}
+
+	if bits < minBits {
+		return minBits
+	}
+	if bits > maxBits {
+		return maxBits
+	}
+	return bits
+}
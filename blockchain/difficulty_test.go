@@ -0,0 +1,152 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"golang-blockchain/wallet"
+	"testing"
+)
+
+func TestRetarget(t *testing.T) {
+	expected := int64(retargetInterval * targetBlockTime)
+
+	tests := []struct {
+		name   string
+		bits   int
+		actual int64
+		want   int
+	}{
+		{"on target", 20, expected, 20},
+		{"slightly fast", 20, expected*2/3 + 1, 20},
+		{"twice as fast", 20, expected / 2, 21},
+		{"four times as fast", 20, expected / 4, 22},
+		{"step is limited", 20, 1, 20 + maxRetargetStep},
+		{"no time at all", 20, 0, 20 + maxRetargetStep},
+		{"negative time", 20, -expected, 20 + maxRetargetStep},
+		{"slightly slow", 20, expected*2 - 1, 20},
+		{"twice as slow", 20, expected * 2, 19},
+		{"four times as slow", 20, expected * 4, 18},
+		{"step is limited when slow", 20, expected * 100, 20 - maxRetargetStep},
+		{"minimum bits", minBits, expected * 4, minBits},
+		{"maximum bits", maxBits, expected / 4, maxBits},
+	}
+	for _, test := range tests {
+		if got := retarget(test.bits, test.actual, expected); got != test.want {
+			t.Errorf("%s: retarget(%d, %d, %d) = %d, expected %d", test.name, test.bits, test.actual, expected, got, test.want)
+		}
+	}
+}
+
+// mineBlockAt mines an empty block with the given timestamp on top of the
+// chain and adds it
+func mineBlockAt(t *testing.T, bc *Blockchain, w *wallet.Wallet, time int64) (*Block, error) {
+	t.Helper()
+
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cbTx, err := CoinbaseTX(string(w.Address()), "", height+1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.blockTemplate([]*Transaction{cbTx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Time = time
+	if err := block.Mine(context.Background(), MiningOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = bc.AddBlock(block)
+	return block, err
+}
+
+func TestNextBits(t *testing.T) {
+	tests := []struct {
+		name    string
+		spacing int64
+		change  int
+	}{
+		{"on target", targetBlockTime, 0},
+		{"fast blocks", 1, maxRetargetStep},
+		{"slow blocks", 4 * targetBlockTime, -2},
+	}
+	for _, test := range tests {
+		bc, w := newTestChain(t)
+		genesis, err := bc.GetHeader(bc.LastHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for height := 1; height < retargetInterval; height++ {
+			if _, err := mineBlockAt(t, bc, w, genesis.Time+int64(height)*test.spacing); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+
+			prev, err := bc.GetHeader(bc.LastHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bits, err := bc.NextBits(&prev)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := initialBits
+			if height == retargetInterval-1 {
+				want += test.change
+			}
+			if bits != want {
+				t.Errorf("%s: bits after height %d are %d, expected %d", test.name, height, bits, want)
+			}
+		}
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	bc, w := newTestChain(t)
+	genesis, err := bc.GetHeader(bc.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// timestamps may go back in time, as long as they stay after the
+	// median of the previous ones
+	offsets := []int64{5, 9, 6, 12, 7}
+	for _, offset := range offsets {
+		if _, err := mineBlockAt(t, bc, w, genesis.Time+offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prev, err := bc.GetHeader(bc.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	medianTime, err := bc.MedianTimePast(&prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the median of 0, 5, 9, 6, 12 and 7, the upper one of an even count
+	if medianTime != genesis.Time+7 {
+		t.Fatalf("median time past is %d, expected %d", medianTime, genesis.Time+7)
+	}
+
+	block, err := mineBlockAt(t, bc, w, medianTime)
+	if _, ok := err.(*BlockValidationError); !ok {
+		t.Errorf("block at the median time past was not rejected: %v", err)
+	}
+	if bytes.Compare(bc.LastHash, block.Hash) == 0 {
+		t.Error("rejected block became the tip")
+	}
+
+	template, err := bc.blockTemplate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.Time <= medianTime {
+		t.Errorf("block template time %d is not after the median time past %d", template.Time, medianTime)
+	}
+}
@@ -0,0 +1,372 @@
+package blockchain
+
+// Blocks, transactions and outputs are stored, hashed and sent over the
+// network in a canonical binary format that doesn't depend on Go:
+//
+//	uint32, int64   big-endian, int64 values are two's complement
+//	bytes           uint32 length followed by the bytes, a nil and an
+//	                empty slice are encoded the same way
+//
+//	TXOutput        int64 Value | bytes ScriptPubKey
+//	UnspentOutput   TXOutput | int64 Height | uint32 Coinbase, 0 or 1
+//	TXInput         bytes ID | int64 Out | bytes ScriptSig
+//	Transaction     uint32 txVersion | bytes ID |
+//	                uint32 count | TXInput... | uint32 count | TXOutput...
+//	BlockHeader     uint32 Version | bytes HashPrevBlock | bytes MerkleRoot |
+//	                int64 Time | int64 Bits | int64 Nonce | int64 Height
+//	Block           BlockHeader | uint32 count | bytes Transaction...
+//	RawTransaction  uint32 rawTxVersion | bytes Transaction |
+//	                uint32 count | TXOutput...
+//
+// A transaction ID is the SHA-256 of the transaction encoded with an empty
+// ID and without the ScriptSig of its inputs, except for a coinbase, a
+// block hash is the SHA-256 of its header. An input signs the SHA-256 of
+// the transaction encoded the same way but with the ScriptPubKey of the
+// output it spends as its own ScriptSig, followed by int64 Value of that
+// output. For example TXOutput{Value: 10,
+// ScriptPubKey: Script{0xab, 0xcd}} is encoded as
+// 000000000000000a 00000002 abcd, and the encoding of a transaction with
+// one unsigned input spending output 1 of transaction 0x01, and that
+// output, is
+//
+//	00000003 00000000
+//	00000001 00000001 01 0000000000000001 00000000
+//	00000001 000000000000000a 00000002 abcd
+//
+// A raw transaction holds as many outputs as its transaction has inputs,
+// the output spent by each of them.
+//
+// Decoding rejects unknown versions, truncated data and trailing bytes, so
+// every value has exactly one encoding.
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	// txVersion is the version of the transaction encoding, version 2
+	// replaced the signature and public key of inputs and the public key
+	// hash of outputs with scripts, in version 3 signatures commit to the
+	// value of the output an input spends
+	txVersion = 3
+	// blockVersion is the version of block headers
+	blockVersion = 1
+	// rawTxVersion is the version of the raw transaction encoding
+	rawTxVersion = 1
+)
+
+// encoder builds the canonical encoding of a value
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.uint32(uint32(len(v)))
+	e.buf.Write(v)
+}
+
+func (e *encoder) output(out TXOutput) {
+	e.int64(int64(out.Value))
+	e.bytes(out.ScriptPubKey)
+}
+
+func (e *encoder) unspent(out UnspentOutput) {
+	e.output(out.TXOutput)
+	e.int64(int64(out.Height))
+	if out.Coinbase {
+		e.uint32(1)
+	} else {
+		e.uint32(0)
+	}
+}
+
+func (e *encoder) input(in TXInput) {
+	e.bytes(in.ID)
+	e.int64(int64(in.Out))
+	e.bytes(in.ScriptSig)
+}
+
+func (e *encoder) transaction(tx *Transaction) {
+	e.uint32(txVersion)
+	e.bytes(tx.ID)
+	e.uint32(uint32(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		e.input(in)
+	}
+	e.uint32(uint32(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		e.output(out)
+	}
+}
+
+func (e *encoder) rawTransaction(raw *RawTransaction) {
+	e.uint32(rawTxVersion)
+	e.bytes(raw.Tx.Serialize())
+	e.uint32(uint32(len(raw.Spent)))
+	for _, out := range raw.Spent {
+		e.output(out)
+	}
+}
+
+func (e *encoder) header(h *BlockHeader) {
+	e.uint32(uint32(h.Version))
+	e.bytes(h.HashPrevBlock)
+	e.bytes(h.MerkleRoot)
+	e.int64(h.Time)
+	e.int64(int64(h.Bits))
+	e.int64(int64(h.Nonce))
+	e.int64(int64(h.Height))
+}
+
+// transactions encodes the body of a block, which is stored apart from
+// its header
+func (e *encoder) transactions(txs []*Transaction) {
+	e.uint32(uint32(len(txs)))
+	for _, tx := range txs {
+		e.bytes(tx.Serialize())
+	}
+}
+
+func (e *encoder) block(b *Block) {
+	e.header(&b.BlockHeader)
+	e.transactions(b.Transactions)
+}
+
+// Encoder writes the canonical encoding of the values of other packages,
+// such as the messages nodes exchange, with the same primitives as blocks
+// and transactions
+type Encoder struct {
+	e encoder
+}
+
+// Uint32 appends a big-endian uint32
+func (e *Encoder) Uint32(v uint32) {
+	e.e.uint32(v)
+}
+
+// Int64 appends a big-endian int64
+func (e *Encoder) Int64(v int64) {
+	e.e.int64(v)
+}
+
+// Bytes appends the length of v and v
+func (e *Encoder) Bytes(v []byte) {
+	e.e.bytes(v)
+}
+
+// Data returns the encoding
+func (e *Encoder) Data() []byte {
+	return e.e.buf.Bytes()
+}
+
+// Decoder reads what an Encoder wrote, the first error sticks and later
+// reads return zero values
+type Decoder struct {
+	d decoder
+}
+
+// NewDecoder returns a Decoder reading data
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{decoder{data: data}}
+}
+
+// Uint32 reads a big-endian uint32
+func (d *Decoder) Uint32() uint32 {
+	return d.d.uint32()
+}
+
+// Int64 reads a big-endian int64
+func (d *Decoder) Int64() int64 {
+	return d.d.int64()
+}
+
+// Bytes reads a length and that many bytes
+func (d *Decoder) Bytes() []byte {
+	return d.d.bytes()
+}
+
+// Count reads the number of items that follow, each taking at least
+// minSize bytes
+func (d *Decoder) Count(minSize int) int {
+	return d.d.count(minSize)
+}
+
+// Finish returns ErrMalformedData when the data is truncated or not fully
+// read
+func (d *Decoder) Finish() error {
+	return d.d.finish()
+}
+
+// decoder reads a canonical encoding. The first error sticks, later reads
+// return zero values
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = ErrMalformedData
+		return nil
+	}
+
+	v := d.data[:n]
+	d.data = d.data[n:]
+
+	return v
+}
+
+func (d *decoder) uint32() uint32 {
+	v := d.next(4)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(v)
+}
+
+func (d *decoder) int64() int64 {
+	v := d.next(8)
+	if v == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if n == 0 {
+		return nil
+	}
+	return append([]byte{}, d.next(int(n))...)
+}
+
+// count reads the number of items that follow, each taking at least
+// minSize bytes, so that bogus counts fail before allocating
+func (d *decoder) count(minSize int) int {
+	n := int(d.uint32())
+	if d.err == nil && n > len(d.data)/minSize {
+		d.err = ErrMalformedData
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) version(expected uint32) {
+	if v := d.uint32(); d.err == nil && v != expected {
+		d.err = ErrUnknownVersion
+	}
+}
+
+// finish reports the decoding error, data must be fully consumed
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.err = ErrMalformedData
+	}
+	return d.err
+}
+
+func (d *decoder) output() TXOutput {
+	return TXOutput{int(d.int64()), d.bytes()}
+}
+
+func (d *decoder) unspent() UnspentOutput {
+	out := UnspentOutput{TXOutput: d.output(), Height: int(d.int64())}
+	switch d.uint32() {
+	case 0:
+	case 1:
+		out.Coinbase = true
+	default:
+		if d.err == nil {
+			d.err = ErrMalformedData
+		}
+	}
+
+	return out
+}
+
+func (d *decoder) input() TXInput {
+	return TXInput{d.bytes(), int(d.int64()), d.bytes()}
+}
+
+func (d *decoder) transaction() Transaction {
+	var tx Transaction
+
+	d.version(txVersion)
+	tx.ID = d.bytes()
+	for n := d.count(16); n > 0; n-- {
+		tx.Inputs = append(tx.Inputs, d.input())
+	}
+	for n := d.count(12); n > 0; n-- {
+		tx.Outputs = append(tx.Outputs, d.output())
+	}
+
+	return tx
+}
+
+func (d *decoder) rawTransaction() *RawTransaction {
+	var raw RawTransaction
+
+	d.version(rawTxVersion)
+	tx, err := DeserializeTransaction(d.bytes())
+	if err != nil && d.err == nil {
+		d.err = err
+	}
+	raw.Tx = tx
+	for n := d.count(12); n > 0; n-- {
+		raw.Spent = append(raw.Spent, d.output())
+	}
+	if d.err == nil && (len(raw.Spent) != len(raw.Tx.Inputs) || raw.Tx.IsCoinbase()) {
+		d.err = ErrMalformedData
+	}
+
+	return &raw
+}
+
+func (d *decoder) header() *BlockHeader {
+	var h BlockHeader
+
+	d.version(blockVersion)
+	h.Version = blockVersion
+	h.HashPrevBlock = d.bytes()
+	h.MerkleRoot = d.bytes()
+	h.Time = d.int64()
+	h.Bits = int(d.int64())
+	h.Nonce = int(d.int64())
+	h.Height = int(d.int64())
+
+	return &h
+}
+
+func (d *decoder) transactions() []*Transaction {
+	var txs []*Transaction
+
+	for n := d.count(4); n > 0 && d.err == nil; n-- {
+		tx, err := DeserializeTransaction(d.bytes())
+		if err != nil && d.err == nil {
+			d.err = err
+		}
+		txs = append(txs, &tx)
+	}
+
+	return txs
+}
+
+func (d *decoder) block() *Block {
+	return &Block{BlockHeader: *d.header(), Transactions: d.transactions()}
+}
@@ -0,0 +1,235 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+// fromHex decodes hex written in groups separated by spaces
+func fromHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+const (
+	goldenOutput  = "000000000000000a 00000002 abcd"
+	goldenInput   = "00000001 01 0000000000000001 00000002 0203"
+	goldenUnspent = goldenOutput + " 0000000000000007 00000001"
+	goldenTxID    = "93cf6171ddee21660b8bff3fd91f8588e56abf3009d2bb25394a2cc9d4962281"
+	goldenTx      = "00000003 00000020 " + goldenTxID + " 00000001 " + goldenInput + " 00000001 " + goldenOutput
+	goldenHeader  = "00000001 00000002 1112 00000001 21 000000006553f100 000000000000000f 000000000000002a 0000000000000003"
+	goldenHash    = "2bbf3b5b7e74ccc07d3b35d6eab355641639644a3003fe835a3dc6149cb1015b"
+	goldenBlock   = goldenHeader + " 00000001 00000051 " + goldenTx
+)
+
+func goldenValues(t *testing.T) (TXOutput, TXInput, Transaction, BlockHeader) {
+	out := TXOutput{Value: 10, ScriptPubKey: Script{0xab, 0xcd}}
+	in := TXInput{ID: []byte{0x01}, Out: 1, ScriptSig: Script{0x02, 0x03}}
+	tx := Transaction{
+		ID:      fromHex(t, goldenTxID),
+		Inputs:  []TXInput{in},
+		Outputs: []TXOutput{out},
+	}
+	header := BlockHeader{
+		Version:       blockVersion,
+		HashPrevBlock: []byte{0x11, 0x12},
+		MerkleRoot:    []byte{0x21},
+		Time:          1700000000,
+		Bits:          15,
+		Nonce:         42,
+		Height:        3,
+	}
+
+	return out, in, tx, header
+}
+
+// codec encodes and decodes one of the types with a canonical encoding
+type codec struct {
+	name   string
+	golden string
+	value  interface{}
+	encode func() []byte
+	decode func(data []byte) (interface{}, error)
+}
+
+func codecs(t *testing.T) []codec {
+	out, in, tx, header := goldenValues(t)
+	unspent := UnspentOutput{TXOutput: out, Height: 7, Coinbase: true}
+	block := &Block{BlockHeader: header, Transactions: []*Transaction{&tx}}
+	block.Hash = block.BlockHash()
+
+	return []codec{
+		{"TXOutput", goldenOutput, out,
+			func() []byte {
+				var e encoder
+				e.output(out)
+				return e.buf.Bytes()
+			},
+			func(data []byte) (interface{}, error) {
+				d := decoder{data: data}
+				out := d.output()
+				return out, d.finish()
+			}},
+		{"TXInput", goldenInput, in,
+			func() []byte {
+				var e encoder
+				e.input(in)
+				return e.buf.Bytes()
+			},
+			func(data []byte) (interface{}, error) {
+				d := decoder{data: data}
+				in := d.input()
+				return in, d.finish()
+			}},
+		{"UnspentOutput", goldenUnspent, unspent,
+			unspent.serialize,
+			func(data []byte) (interface{}, error) {
+				return deserializeUnspentOutput(data)
+			}},
+		{"Transaction", goldenTx, tx,
+			tx.Serialize,
+			func(data []byte) (interface{}, error) {
+				return DeserializeTransaction(data)
+			}},
+		{"BlockHeader", goldenHeader, header,
+			header.Serialize,
+			func(data []byte) (interface{}, error) {
+				header, err := DeserializeHeader(data)
+				if err != nil {
+					return nil, err
+				}
+				return *header, nil
+			}},
+		{"Block", goldenBlock, block,
+			block.Serialize,
+			func(data []byte) (interface{}, error) {
+				return Deserialize(data)
+			}},
+	}
+}
+
+func TestEncodingGoldenVectors(t *testing.T) {
+	for _, c := range codecs(t) {
+		golden := fromHex(t, c.golden)
+		if encoded := c.encode(); bytes.Compare(encoded, golden) != 0 {
+			t.Errorf("%s is encoded as %x, expected %x", c.name, encoded, golden)
+		}
+	}
+
+	_, _, tx, header := goldenValues(t)
+	if id := tx.computeID(); hex.EncodeToString(id) != goldenTxID {
+		t.Errorf("transaction ID is %x, expected %s", id, goldenTxID)
+	}
+	if hash := header.BlockHash(); hex.EncodeToString(hash) != goldenHash {
+		t.Errorf("block hash is %x, expected %s", hash, goldenHash)
+	}
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	for _, c := range codecs(t) {
+		decoded, err := c.decode(fromHex(t, c.golden))
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, c.value) {
+			t.Errorf("%s decodes to %+v, expected %+v", c.name, decoded, c.value)
+		}
+	}
+}
+
+func TestDecodingRejectsNonCanonicalData(t *testing.T) {
+	for _, c := range codecs(t) {
+		golden := fromHex(t, c.golden)
+
+		for i := 0; i < len(golden); i++ {
+			if _, err := c.decode(golden[:i]); err != ErrMalformedData {
+				t.Errorf("%s truncated to %d bytes: got %v, expected ErrMalformedData", c.name, i, err)
+			}
+		}
+		if _, err := c.decode(append(golden, 0)); err != ErrMalformedData {
+			t.Errorf("%s with a trailing byte: got %v, expected ErrMalformedData", c.name, err)
+		}
+	}
+
+	// a Coinbase flag other than 0 or 1 would give a second encoding
+	unspent := fromHex(t, goldenUnspent)
+	unspent[len(unspent)-1] = 2
+	if _, err := deserializeUnspentOutput(unspent); err != ErrMalformedData {
+		t.Errorf("unspent output with Coinbase flag 2: got %v, expected ErrMalformedData", err)
+	}
+}
+
+func TestDecodingRejectsUnknownVersions(t *testing.T) {
+	tx := fromHex(t, goldenTx)
+	tx[3] = txVersion + 1
+	if _, err := DeserializeTransaction(tx); err != ErrUnknownVersion {
+		t.Errorf("transaction version %d: got %v, expected ErrUnknownVersion", txVersion+1, err)
+	}
+
+	header := fromHex(t, goldenHeader)
+	header[3] = blockVersion + 1
+	if _, err := DeserializeHeader(header); err != ErrUnknownVersion {
+		t.Errorf("header version %d: got %v, expected ErrUnknownVersion", blockVersion+1, err)
+	}
+
+	block := fromHex(t, goldenBlock)
+	block[3] = blockVersion + 1
+	if _, err := Deserialize(block); err != ErrUnknownVersion {
+		t.Errorf("block version %d: got %v, expected ErrUnknownVersion", blockVersion+1, err)
+	}
+
+	// the version of the transactions of a block is checked as well
+	block = fromHex(t, goldenBlock)
+	block[len(fromHex(t, goldenHeader))+11] = txVersion + 1
+	if _, err := Deserialize(block); err != ErrUnknownVersion {
+		t.Errorf("block transaction version %d: got %v, expected ErrUnknownVersion", txVersion+1, err)
+	}
+}
+
+func TestOldFormatChainIsRejected(t *testing.T) {
+	dataDir := t.TempDir()
+	_, _, tx, header := goldenValues(t)
+	block := &Block{BlockHeader: header, Transactions: []*Transaction{&tx}}
+	block.Hash = block.BlockHash()
+
+	// older versions stored the gob encoding of whole blocks under their
+	// hash and the hash of the tip under "lh"
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(block); err != nil {
+		t.Fatal(err)
+	}
+	db, err := openDB(dbDir(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(block.Hash, encoded.Bytes()); err != nil {
+			return err
+		}
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bc, err := ContinueBlockchain(dataDir); err != ErrChainFormat {
+		if err == nil {
+			bc.DB.Close()
+		}
+		t.Errorf("opening a chain of gob encoded blocks: got %v, expected ErrChainFormat", err)
+	}
+}
@@ -0,0 +1,54 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrChainNotFound is returned when there is no blockchain database
+	ErrChainNotFound = errors.New("No existing blockchain found")
+	// ErrChainFormat is returned when opening a blockchain database written
+	// in an older storage format, such as the gob encoding of blocks or a
+	// database without the coinbase maturity
+	ErrChainFormat = errors.New("Blockchain database has an old format, remove the blocks directory and create the chain again")
+	// ErrChainExists is returned when creating a blockchain over an existing one
+	ErrChainExists = errors.New("Blockchain already exists")
+	// ErrBlockNotFound is returned when a requested block is not stored
+	ErrBlockNotFound = errors.New("Block not found")
+	// ErrTxNotFound is returned when a transaction is not in the chain,
+	// including the previous transactions referenced by inputs
+	ErrTxNotFound = errors.New("Transaction does not exist")
+	// ErrInsufficientFunds is returned when a wallet can't pay for a transaction
+	ErrInsufficientFunds = errors.New("Not enough funds")
+	// ErrInvalidAddress is returned for malformed addresses or bad checksums
+	ErrInvalidAddress = errors.New("Address is not valid")
+	// ErrInvalidSignature is returned when the script of an input doesn't
+	// unlock the output it spends
+	ErrInvalidSignature = errors.New("Transaction signature is invalid")
+	// ErrTxIncomplete is returned when sending a raw transaction some
+	// inputs of which are not signed yet
+	ErrTxIncomplete = errors.New("Transaction is not fully signed")
+	// ErrScriptFailed is returned when a script is malformed or doesn't
+	// leave true on the stack
+	ErrScriptFailed = errors.New("Script evaluation failed")
+	// ErrMalformedData is returned when decoding truncated or invalid data
+	ErrMalformedData = errors.New("Malformed data")
+	// ErrUnknownVersion is returned when decoding data encoded with an
+	// unsupported format version
+	ErrUnknownVersion = errors.New("Unknown encoding version")
+)
+
+// TxValidationError describes why the mempool rejects a transaction
+type TxValidationError struct {
+	ID     []byte
+	Reason string
+}
+
+func (e *TxValidationError) Error() string {
+	return fmt.Sprintf("Transaction %x is rejected: %s", e.ID, e.Reason)
+}
+
+func invalidTx(tx *Transaction, format string, a ...interface{}) error {
+	return &TxValidationError{tx.ID, fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,60 @@
+package blockchain
+
+import (
+	"github.com/dgraph-io/badger"
+)
+
+var headerPrefix = []byte("header-")
+
+// headerKey returns the key of the header of the block with the given
+// hash. The transactions of the block are stored under the hash itself
+func headerKey(blockHash []byte) []byte {
+	return append(append([]byte{}, headerPrefix...), blockHash...)
+}
+
+// getHeader reads a block header as part of the caller's database
+// transaction, it returns ErrBlockNotFound when the block is not stored
+func getHeader(txn *badger.Txn, blockHash []byte) (*BlockHeader, error) {
+	item, err := txn.Get(headerKey(blockHash))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrBlockNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	headerData, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return DeserializeHeader(headerData)
+}
+
+// GetHeader returns the header of the block with the given hash without
+// reading its transactions, or ErrBlockNotFound
+func (bc *Blockchain) GetHeader(blockHash []byte) (BlockHeader, error) {
+	var header *BlockHeader
+
+	err := bc.DB.View(func(txn *badger.Txn) error {
+		var err error
+		header, err = getHeader(txn, blockHash)
+		return err
+	})
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	return *header, nil
+}
+
+// GetHeaderByHeight returns the header of the block of the main chain at
+// the given height
+func (bc *Blockchain) GetHeaderByHeight(height int) (BlockHeader, error) {
+	blockHash, err := bc.GetBlockHashByHeight(height)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	return bc.GetHeader(blockHash)
+}
@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"github.com/dgraph-io/badger"
+)
+
+var heightPrefix = []byte("height-")
+
+// heightKey returns the key of the height index entry of the block of the
+// main chain at the given height
+func heightKey(height int) []byte {
+	return append(append([]byte{}, heightPrefix...), IntToHex(int64(height))...)
+}
+
+// GetBlockHashByHeight returns the hash of the block of the main chain at
+// the given height, it returns ErrBlockNotFound when the chain is shorter
+// than that
+func (bc *Blockchain) GetBlockHashByHeight(height int) ([]byte, error) {
+	var blockHash []byte
+
+	err := bc.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(heightKey(height))
+		if err != nil {
+			return err
+		}
+		blockHash, err = item.ValueCopy(nil)
+
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrBlockNotFound
+	}
+
+	return blockHash, err
+}
+
+// GetBlockByHeight returns the block of the main chain at the given height
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	blockHash, err := bc.GetBlockHashByHeight(height)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return bc.GetBlock(blockHash)
+}
+
+// ReindexHeights rebuilds the height index from the headers of the main
+// chain
+func (bc *Blockchain) ReindexHeights() error {
+	db := bc.DB
+
+	if err := (UTXOSet{bc}).deleteByPrefix(heightPrefix); err != nil {
+		return err
+	}
+
+	txn := db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+	writes := 0
+
+	iter := bc.Iterator()
+	for {
+		blockHash := iter.currentHash
+		header, err := iter.NextHeader()
+		if err != nil {
+			return err
+		}
+
+		if writes == utxoBatchSize {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = db.NewTransaction(true)
+			writes = 0
+		}
+
+		err = txn.Set(heightKey(header.Height), blockHash)
+		if err != nil {
+			return err
+		}
+		writes++
+
+		if len(header.HashPrevBlock) == 0 {
+			break
+		}
+	}
+
+	return txn.Commit()
+}
@@ -1,8 +1,6 @@
 package blockchain
 
 import (
-	"log"
-
 	"github.com/dgraph-io/badger"
 )
 
@@ -13,28 +11,38 @@ type Iterator struct {
 }
 
 // Next returns a next block starting from tip
-func (iter *Iterator) Next() *Block {
+func (iter *Iterator) Next() (*Block, error) {
 	var block *Block
 
 	err := iter.DB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.currentHash)
-		if err != nil {
-			return err
-		}
-		var encodedBlock []byte
-		item.Value(func(val []byte) error {
-			encodedBlock = val
-			return nil
-		})
-		block = Deserialize(encodedBlock)
-
+		var err error
+		block, err = getBlock(txn, iter.currentHash)
 		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	iter.currentHash = block.HashPrevBlock
 
-	return block
+	return block, nil
+}
+
+// NextHeader returns the header of the next block starting from tip,
+// without reading its transactions
+func (iter *Iterator) NextHeader() (*BlockHeader, error) {
+	var header *BlockHeader
+
+	err := iter.DB.View(func(txn *badger.Txn) error {
+		var err error
+		header, err = getHeader(txn, iter.currentHash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	iter.currentHash = header.HashPrevBlock
+
+	return header, nil
 }
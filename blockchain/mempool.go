@@ -0,0 +1,337 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"golang-blockchain/wallet"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+)
+
+var mempoolPrefix = []byte("mempool-")
+
+// maxBlockTxs limits the number of pending transactions packed into one block
+const maxBlockTxs = 100
+
+// Mempool holds verified transactions waiting to be mined, it is stored
+// in its own key prefix of the blockchain database
+type Mempool struct {
+	Blockchain *Blockchain
+}
+
+func mempoolKey(txID []byte) []byte {
+	return append(append([]byte{}, mempoolPrefix...), txID...)
+}
+
+func outpoint(txID []byte, outIdx int) string {
+	return fmt.Sprintf("%x:%d", txID, outIdx)
+}
+
+// Add verifies a transaction and puts it into the mempool. It must follow
+// the rules of a transaction of the next block, and the outputs it spends
+// must not be spent by a pending transaction already, or it is rejected
+// with a *TxValidationError
+func (pool Mempool) Add(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return invalidTx(tx, "coinbase transactions can't be added to the mempool")
+	}
+	known, err := pool.Has(tx.ID)
+	if err != nil {
+		return err
+	}
+	if known {
+		return invalidTx(tx, "transaction is already in the mempool")
+	}
+
+	height, err := pool.Blockchain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	if _, err := validateTransaction(tx, height+1, pool.Blockchain.CoinbaseMaturity, UTXOSet{pool.Blockchain}.FindOutput); err != nil {
+		return err
+	}
+
+	spentBy, err := pool.spentOutputs()
+	if err != nil {
+		return err
+	}
+	for _, in := range tx.Inputs {
+		key := outpoint(in.ID, in.Out)
+		if pendingID, ok := spentBy[key]; ok {
+			return invalidTx(tx, "transaction double-spends output %s of pending transaction %x", key, pendingID)
+		}
+	}
+
+	return pool.Blockchain.DB.Update(func(txn *badger.Txn) error {
+		return txn.Set(mempoolKey(tx.ID), tx.Serialize())
+	})
+}
+
+// Has checks whether a transaction is pending
+func (pool Mempool) Has(txID []byte) (bool, error) {
+	_, err := pool.Get(txID)
+	if err == ErrTxNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Get returns a pending transaction by its ID, or ErrTxNotFound
+func (pool Mempool) Get(txID []byte) (Transaction, error) {
+	var tx Transaction
+
+	err := pool.Blockchain.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(mempoolKey(txID))
+		if err != nil {
+			return err
+		}
+		data, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		tx, err = DeserializeTransaction(data)
+
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return tx, ErrTxNotFound
+	}
+
+	return tx, err
+}
+
+// Transactions returns all pending transactions
+func (pool Mempool) Transactions() ([]Transaction, error) {
+	var txs []Transaction
+
+	err := pool.Blockchain.DB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(mempoolPrefix); it.ValidForPrefix(mempoolPrefix); it.Next() {
+			data, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			tx, err := DeserializeTransaction(data)
+			if err != nil {
+				return err
+			}
+			txs = append(txs, tx)
+		}
+
+		return nil
+	})
+
+	return txs, err
+}
+
+// spentOutputs returns the outpoints spent by pending transactions, with
+// the ID of the transaction spending each of them
+func (pool Mempool) spentOutputs() (map[string][]byte, error) {
+	spentBy := make(map[string][]byte)
+
+	txs, err := pool.Transactions()
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range txs {
+		for _, in := range tx.Inputs {
+			spentBy[outpoint(in.ID, in.Out)] = tx.ID
+		}
+	}
+
+	return spentBy, nil
+}
+
+// Count returns the number of pending transactions
+func (pool Mempool) Count() (int, error) {
+	txs, err := pool.Transactions()
+
+	return len(txs), err
+}
+
+// Mine mines the block template of the pool and stores it. It returns
+// ctx.Err() when mining is cancelled
+func (pool Mempool) Mine(ctx context.Context, minerAddress string, opts MiningOptions) (*Block, error) {
+	block, err := pool.BlockTemplate(minerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	err = block.Mine(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = pool.Blockchain.AddBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// BlockTemplate packs pending transactions and a coinbase paying
+// minerAddress into a new block on top of the chain, which still has to
+// be mined. The transactions paying the highest fee per byte go first and
+// their fees are collected by the coinbase. Pending transactions that
+// break a rule of the block are dropped from the pool, the ones spending
+// immature coinbase outputs wait for a later block
+func (pool Mempool) BlockTemplate(minerAddress string) (*Block, error) {
+	if !wallet.ValidateAddress(minerAddress) {
+		return nil, ErrInvalidAddress
+	}
+
+	var pending []pendingTx
+	var stale [][]byte
+	UTXO := UTXOSet{pool.Blockchain}
+	height, err := pool.Blockchain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	height++
+
+	txs, err := pool.Transactions()
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range txs {
+		tx := tx
+		mature, err := UTXO.matureAt(&tx, height)
+		if err != nil {
+			return nil, err
+		}
+		if !mature {
+			// stays pending until the coinbase outputs it spends mature
+			continue
+		}
+		fee, err := validateTransaction(&tx, height, pool.Blockchain.CoinbaseMaturity, UTXO.FindOutput)
+		if _, ok := err.(*TxValidationError); ok {
+			stale = append(stale, tx.ID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, pendingTx{&tx, fee, len(tx.Serialize())})
+	}
+
+	if len(stale) > 0 {
+		if err := pool.Remove(stale); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].fee*pending[j].size > pending[j].fee*pending[i].size
+	})
+	if len(pending) > maxBlockTxs {
+		pending = pending[:maxBlockTxs]
+	}
+
+	fees := 0
+	var blockTxs []*Transaction
+	for _, p := range pending {
+		fees += p.fee
+		blockTxs = append(blockTxs, p.tx)
+	}
+
+	cbTx, err := CoinbaseTX(minerAddress, "", height, fees)
+	if err != nil {
+		return nil, err
+	}
+	blockTxs = append([]*Transaction{cbTx}, blockTxs...)
+
+	return pool.Blockchain.blockTemplate(blockTxs)
+}
+
+// pendingTx is a mempool transaction with its fee and serialized size
+type pendingTx struct {
+	tx   *Transaction
+	fee  int
+	size int
+}
+
+// Remove drops transactions from the mempool
+func (pool Mempool) Remove(txIDs [][]byte) error {
+	return pool.Blockchain.DB.Update(func(txn *badger.Txn) error {
+		for _, txID := range txIDs {
+			if err := txn.Delete(mempoolKey(txID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// removeMinedFromMempool drops the transactions of a connected block from
+// the mempool, together with pending transactions spending the same
+// outputs, as part of the caller's database transaction
+func removeMinedFromMempool(txn *badger.Txn, block *Block) error {
+	mined := make(map[string]bool)
+	spent := make(map[string]bool)
+
+	for _, tx := range block.Transactions {
+		mined[hex.EncodeToString(tx.ID)] = true
+		if tx.IsCoinbase() == false {
+			for _, in := range tx.Inputs {
+				spent[outpoint(in.ID, in.Out)] = true
+			}
+		}
+	}
+
+	var remove [][]byte
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	for it.Seek(mempoolPrefix); it.ValidForPrefix(mempoolPrefix); it.Next() {
+		item := it.Item()
+		data, err := item.ValueCopy(nil)
+		if err != nil {
+			it.Close()
+			return err
+		}
+		tx, err := DeserializeTransaction(data)
+		if err != nil {
+			it.Close()
+			return err
+		}
+
+		conflicts := mined[hex.EncodeToString(tx.ID)]
+		for _, in := range tx.Inputs {
+			conflicts = conflicts || spent[outpoint(in.ID, in.Out)]
+		}
+		if conflicts {
+			remove = append(remove, item.KeyCopy(nil))
+		}
+	}
+	it.Close()
+
+	for _, key := range remove {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returnToMempool puts the transactions of a disconnected block back into
+// the mempool, as part of the caller's database transaction. The ones that
+// don't fit the new chain are dropped later on, when it gets connected or
+// when the pool is mined
+func returnToMempool(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		if err := txn.Set(mempoolKey(tx.ID), tx.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
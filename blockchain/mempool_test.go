@@ -0,0 +1,157 @@
+package blockchain
+
+import (
+	"golang-blockchain/wallet"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestSendsSkipOutputsSpentByPendingTransactions(t *testing.T) {
+	bc, miner := newTestChain(t)
+	// the coinbases of the genesis block and the next one mature
+	mineBlocks(t, bc, miner, bc.CoinbaseMaturity)
+
+	recipient, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := Mempool{bc}
+	UTXO := UTXOSet{bc}
+	payments := []Payment{{string(recipient.Address()), 10}}
+
+	for i := 0; i < 2; i++ {
+		tx, err := NewTransaction(miner, payments, 1, "", &UTXO)
+		if err != nil {
+			t.Fatalf("send %d: %s", i+1, err)
+		}
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("send %d: %s", i+1, err)
+		}
+	}
+	if count, _ := pool.Count(); count != 2 {
+		t.Fatalf("mempool holds %d transactions, expected 2", count)
+	}
+
+	mineBlocks(t, bc, miner, 1)
+
+	if count, _ := pool.Count(); count != 0 {
+		t.Errorf("mempool holds %d transactions after mining, expected 0", count)
+	}
+	if spendable, _ := balance(t, bc, recipient); spendable != 20 {
+		t.Errorf("recipient balance is %d, expected 20", spendable)
+	}
+	if err := bc.VerifyChain(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCoinbaseMaturityIsConfigurable(t *testing.T) {
+	bc, miner := newMaturityChain(t, 2)
+	recipient, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := Mempool{bc}
+	UTXO := UTXOSet{bc}
+	payments := []Payment{{string(recipient.Address()), 10}}
+
+	// the genesis coinbase can be spent from height 2 on
+	if _, err := NewTransaction(miner, payments, 0, "", &UTXO); err != ErrInsufficientFunds {
+		t.Errorf("immature coinbase was selected: %v", err)
+	}
+	mineBlocks(t, bc, miner, 1)
+	if spendable, immature := balance(t, bc, miner); spendable != 100 || immature != 100 {
+		t.Errorf("miner balance is %d spendable and %d immature, expected 100 and 100", spendable, immature)
+	}
+
+	tx, err := NewTransaction(miner, payments, 0, "", &UTXO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Add(tx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChainsKeepTheirMaturity(t *testing.T) {
+	early, earlyMiner := newMaturityChain(t, 1)
+	late, lateMiner := newMaturityChain(t, 3)
+	mineBlocks(t, early, earlyMiner, 1)
+	mineBlocks(t, late, lateMiner, 1)
+
+	// the next block is at height 2 on both chains
+	if spendable, immature := balance(t, early, earlyMiner); spendable != 200 || immature != 0 {
+		t.Errorf("balance with a maturity of 1 is %d spendable and %d immature, expected 200 and 0", spendable, immature)
+	}
+	if spendable, immature := balance(t, late, lateMiner); spendable != 0 || immature != 200 {
+		t.Errorf("balance with a maturity of 3 is %d spendable and %d immature, expected 0 and 200", spendable, immature)
+	}
+
+	w, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := t.TempDir()
+	bc, err := InitBlockchain(string(w.Address()), dataDir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.DB.Close()
+
+	bc, err = ContinueBlockchain(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc.CoinbaseMaturity != 3 {
+		t.Errorf("reopened chain has a maturity of %d, expected 3", bc.CoinbaseMaturity)
+	}
+	err = bc.DB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(maturityKey)
+	})
+	bc.DB.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bc, err := ContinueBlockchain(dataDir); err != ErrChainFormat {
+		if err == nil {
+			bc.DB.Close()
+		}
+		t.Errorf("chain without a maturity was opened: %v", err)
+	}
+}
+
+func TestMempoolRejectsTransactionsBlocksReject(t *testing.T) {
+	bc, w := newTestChain(t)
+
+	empty := &Transaction{}
+	noInputs := &Transaction{Outputs: outputsTo(w, 5)}
+	for _, tx := range []*Transaction{empty, noInputs} {
+		tx.ID = tx.computeID()
+		if err := (Mempool{bc}).Add(tx); err == nil {
+			t.Errorf("mempool accepted %v", tx)
+		} else if _, ok := err.(*TxValidationError); !ok {
+			t.Error(err)
+		}
+	}
+}
+
+func TestBlockTemplateDropsInvalidPendingTransactions(t *testing.T) {
+	bc, w := newTestChain(t)
+
+	// stored by a node that didn't check it
+	tx := &Transaction{}
+	tx.ID = tx.computeID()
+	err := bc.DB.Update(func(txn *badger.Txn) error {
+		return txn.Set(mempoolKey(tx.ID), tx.Serialize())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineBlocks(t, bc, w, 2)
+	if count, _ := (Mempool{bc}).Count(); count != 0 {
+		t.Errorf("mempool holds %d transactions, expected 0", count)
+	}
+}
@@ -1,83 +1,177 @@
 package blockchain
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
-	"log"
+	"errors"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
-	maxNonce = math.MaxInt64
+	// maxNonce bounds the nonces tried for a header, the extra nonce of the
+	// coinbase changes the header when they are all used up
+	maxNonce = math.MaxUint32
+
+	// errNonceExhausted is returned by Run when no nonce satisfies the target
+	errNonceExhausted = errors.New("nonce space exhausted")
+)
+
+const (
+	// hashBatch is the number of hashes a worker tries between checks for
+	// cancellation and updates of the hash counter
+	hashBatch = 1024
+	// progressInterval is the time between two progress reports
+	progressInterval = time.Second
 )
 
-const targetBits = 15
+// MiningOptions configures the proof of work search
+type MiningOptions struct {
+	// Workers is the number of goroutines searching for a nonce, all the
+	// CPUs are used when it is 0
+	Workers int
+	// Progress, when not nil, is called every second during the search
+	// with the number of hashes tried so far
+	Progress func(hashes uint64, elapsed time.Duration)
+}
 
-// ProofOfWork represents a proof of work
+// ProofOfWork represents a proof of work over a block header
 type ProofOfWork struct {
-	block  *Block
+	header *BlockHeader
 	target *big.Int
 }
 
 // NewProofOfWork returns a new ProofOfWork
-func NewProofOfWork(b *Block) *ProofOfWork {
+func NewProofOfWork(h *BlockHeader) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target.Lsh(target, uint(256-h.Bits))
 
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{h, target}
 
 	return pow
 }
 
+// prepareData returns the encoding of the header with the given nonce
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.HashPrevBlock,
-			pow.block.HashTransactions(),
-			IntToHex(pow.block.Time),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
-
-	return data
+	header := *pow.header
+	header.Nonce = nonce
+
+	return header.Serialize()
 }
 
-// Run is used to perform a proof of work
-func (pow *ProofOfWork) Run() (int, []byte) {
+// Run searches for a nonce giving the header a hash below the target. The
+// nonce space is split between opts.Workers goroutines, the search stops
+// when one of them succeeds, when ctx is done, in which case ctx.Err() is
+// returned, or when every nonce was tried, in which case errNonceExhausted
+// is returned
+func (pow *ProofOfWork) Run(ctx context.Context, opts MiningOptions) (int, []byte, error) {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var hashes uint64
+	var wg sync.WaitGroup
+	found := make(chan int, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(first int) {
+			defer wg.Done()
+			pow.search(ctx, first, workers, &hashes, found)
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	start := time.Now()
+
+	for {
+		select {
+		case nonce := <-found:
+			cancel()
+			<-done
+			return nonce, pow.Hash(nonce), nil
+		case <-done:
+			select {
+			case nonce := <-found:
+				return nonce, pow.Hash(nonce), nil
+			default:
+			}
+			if ctx.Err() != nil {
+				return 0, nil, ctx.Err()
+			}
+			return 0, nil, errNonceExhausted
+		case <-ticker.C:
+			if opts.Progress != nil {
+				opts.Progress(atomic.LoadUint64(&hashes), time.Since(start))
+			}
+		}
+	}
+}
+
+// search tries the nonces first, first+step, first+2*step... and sends the
+// first one satisfying the target to found
+func (pow *ProofOfWork) search(ctx context.Context, first, step int, hashes *uint64, found chan<- int) {
 	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
-
-	//fmt.Printf("Mining the block containing \"%s\"\n", pow.block.Data)
-	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash)
-		hashInt.SetBytes(hash[:])
 
+	// the nonce is encoded in the 8 bytes before the height, which ends
+	// the header, so it is patched in place instead of encoding the whole
+	// header for every attempt
+	data := pow.prepareData(0)
+	nonceBytes := data[len(data)-16 : len(data)-8]
+
+	tried := 0
+	for nonce := first; nonce <= maxNonce; nonce += step {
+		if tried == hashBatch {
+			atomic.AddUint64(hashes, uint64(tried))
+			tried = 0
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
+		binary.BigEndian.PutUint64(nonceBytes, uint64(nonce))
+		hash := sha256.Sum256(data)
+		tried++
+
+		hashInt.SetBytes(hash[:])
 		if hashInt.Cmp(pow.target) == -1 {
-			break
-		} else {
-			nonce++
+			atomic.AddUint64(hashes, uint64(tried))
+			found <- nonce
+			return
 		}
 	}
-	fmt.Print("\n\n")
+	atomic.AddUint64(hashes, uint64(tried))
+}
 
-	return nonce, hash[:]
+// Hash returns the hash of the block with the given nonce
+func (pow *ProofOfWork) Hash(nonce int) []byte {
+	hash := sha256.Sum256(pow.prepareData(nonce))
+
+	return hash[:]
 }
 
 // Validate validates block's PoW
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	hash := pow.Hash(pow.header.Nonce)
+	hashInt.SetBytes(hash)
 
 	isValid := hashInt.Cmp(pow.target) == -1
 
@@ -86,11 +180,8 @@ func (pow *ProofOfWork) Validate() bool {
 
 // IntToHex converts an int64 to a byte array
 func IntToHex(num int64) []byte {
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.BigEndian, num)
-	if err != nil {
-		log.Panic(err)
-	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(num))
 
-	return buff.Bytes()
+	return b[:]
 }
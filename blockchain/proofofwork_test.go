@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"golang-blockchain/wallet"
+	"testing"
+	"time"
+)
+
+// testBlock returns an unmined block with a coinbase paying a new wallet
+func testBlock(t *testing.T, bits int) *Block {
+	t.Helper()
+
+	w, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cbTx, err := CoinbaseTX(string(w.Address()), "coinbase data", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newBlock([]*Transaction{cbTx}, []byte{}, 1, bits)
+}
+
+// setMaxNonce changes maxNonce for the duration of a test
+func setMaxNonce(t *testing.T, nonce int) {
+	t.Helper()
+
+	saved := maxNonce
+	maxNonce = nonce
+	t.Cleanup(func() { maxNonce = saved })
+}
+
+func TestRunReturnsContextError(t *testing.T) {
+	// no nonce satisfies a target of 2^1
+	block := testBlock(t, 255)
+	pow := NewProofOfWork(&block.BlockHeader)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := pow.Run(cancelled, MiningOptions{Workers: 2}); err != context.Canceled {
+		t.Errorf("cancelled search returned %v, expected context.Canceled", err)
+	}
+
+	expiring, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := pow.Run(expiring, MiningOptions{Workers: 2}); err != context.DeadlineExceeded {
+		t.Errorf("expired search returned %v, expected context.DeadlineExceeded", err)
+	}
+
+	if err := block.Mine(cancelled, MiningOptions{}); err != context.Canceled {
+		t.Errorf("cancelled mining returned %v, expected context.Canceled", err)
+	}
+}
+
+func TestMineChangesTheCoinbaseWhenNoncesRunOut(t *testing.T) {
+	setMaxNonce(t, 0)
+
+	// only nonce 0 is tried for each coinbase, it must fail first
+	block := testBlock(t, 10)
+	for NewProofOfWork(&block.BlockHeader).Validate() {
+		block.Time++
+	}
+	coinbase := block.Transactions[0]
+	data := coinbase.Inputs[0].ScriptSig
+	txID := coinbase.ID
+	root := block.MerkleRoot
+
+	if _, _, err := NewProofOfWork(&block.BlockHeader).Run(context.Background(), MiningOptions{Workers: 3}); err != errNonceExhausted {
+		t.Fatalf("search of a single failing nonce returned %v, expected errNonceExhausted", err)
+	}
+	if err := block.Mine(context.Background(), MiningOptions{Workers: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	extra := coinbase.Inputs[0].ScriptSig
+	if len(extra) != len(data)+8 || bytes.Compare(extra[:len(data)], data) != 0 {
+		t.Errorf("coinbase data is %x, expected %x followed by an extra nonce", extra, data)
+	}
+	if bytes.Compare(coinbase.ID, txID) == 0 || bytes.Compare(coinbase.ID, coinbase.Hash()) != 0 {
+		t.Errorf("coinbase ID is %x, expected its new hash %x", coinbase.ID, coinbase.Hash())
+	}
+	if bytes.Compare(block.MerkleRoot, root) == 0 || bytes.Compare(block.MerkleRoot, block.HashTransactions()) != 0 {
+		t.Errorf("Merkle root is %x, expected the root of the new coinbase %x", block.MerkleRoot, block.HashTransactions())
+	}
+	if block.Nonce != 0 || !NewProofOfWork(&block.BlockHeader).Validate() {
+		t.Errorf("nonce %d is not valid", block.Nonce)
+	}
+	if bytes.Compare(block.Hash, block.BlockHash()) != 0 {
+		t.Errorf("block hash is %x, expected %x", block.Hash, block.BlockHash())
+	}
+}
+
+func TestRunWithSeveralWorkers(t *testing.T) {
+	for _, workers := range []int{1, 2, 3, 8} {
+		for i := 0; i < 3; i++ {
+			block := testBlock(t, 14)
+			nonce, hash, err := NewProofOfWork(&block.BlockHeader).Run(context.Background(), MiningOptions{Workers: workers})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			block.Nonce = nonce
+			if !NewProofOfWork(&block.BlockHeader).Validate() {
+				t.Errorf("nonce %d found by %d workers is not valid", nonce, workers)
+			}
+			if bytes.Compare(hash, block.BlockHash()) != 0 {
+				t.Errorf("hash %x found by %d workers is not the hash of the header %x", hash, workers, block.BlockHash())
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"golang-blockchain/wallet"
+)
+
+// RawTransaction is a transaction passed around to be signed away from
+// the chain. It carries the outputs spent by its inputs, in the same
+// order, which is all a signer needs to know what each input signs and
+// how much the transaction spends
+type RawTransaction struct {
+	Tx    Transaction
+	Spent []TXOutput
+}
+
+// NewRawTransaction creates the unsigned transaction NewTransaction would
+// make for the from address, which doesn't have to be in the wallet, so
+// that it can be signed on another machine
+func NewRawTransaction(from string, payments []Payment, fee int, changeAddress string, UTXO *UTXOSet) (*RawTransaction, error) {
+	if !wallet.ValidateAddress(from) {
+		return nil, ErrInvalidAddress
+	}
+
+	tx, err := newUnsignedTransaction(from, payments, fee, changeAddress, UTXO)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := &RawTransaction{Tx: *tx}
+	for _, in := range tx.Inputs {
+		out, ok, err := UTXO.FindOutput(in.ID, in.Out)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrTxNotFound
+		}
+		raw.Spent = append(raw.Spent, out.TXOutput)
+	}
+
+	return raw, nil
+}
+
+// Serialize returns the canonical encoding of a raw transaction
+func (raw *RawTransaction) Serialize() []byte {
+	var e encoder
+	e.rawTransaction(raw)
+
+	return e.buf.Bytes()
+}
+
+// DeserializeRawTransaction decodes a raw transaction, it returns
+// ErrMalformedData or ErrUnknownVersion for data that is not a canonical
+// encoding
+func DeserializeRawTransaction(data []byte) (*RawTransaction, error) {
+	d := decoder{data: data}
+	raw := d.rawTransaction()
+
+	return raw, d.finish()
+}
+
+// Sign signs the inputs spending pay-to-pubkey-hash outputs of the key and
+// returns how many it signed, the other inputs are left as they are
+func (raw *RawTransaction) Sign(privateKey ecdsa.PrivateKey) (int, error) {
+	pubKeyHash := wallet.PublicKeyHash(wallet.PublicKeyBytes(&privateKey.PublicKey))
+
+	signed := 0
+	for inIdx, out := range raw.Spent {
+		if !out.IsLockedWithKey(pubKeyHash) {
+			continue
+		}
+		if err := raw.Tx.signInput(inIdx, &privateKey, out); err != nil {
+			return signed, err
+		}
+		signed++
+	}
+
+	return signed, nil
+}
+
+// Unsigned returns the indexes of the inputs whose script doesn't unlock
+// the output they spend yet
+func (raw *RawTransaction) Unsigned() []int {
+	var unsigned []int
+
+	for inIdx, out := range raw.Spent {
+		if raw.Tx.verifyInput(inIdx, out) != nil {
+			unsigned = append(unsigned, inIdx)
+		}
+	}
+
+	return unsigned
+}
+
+// Fee returns the value of the spent outputs left over by the outputs of
+// the transaction. It returns an error when the values are out of range
+// or the fee would be negative
+func (raw *RawTransaction) Fee() (int, error) {
+	return txFee(&raw.Tx, raw.Spent)
+}
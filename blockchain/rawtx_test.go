@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"golang-blockchain/wallet"
+	"testing"
+)
+
+// newTestRawTx returns an unsigned raw transaction of w on a chain where
+// the genesis coinbase paying w can be spent
+func newTestRawTx(t *testing.T) (*Blockchain, *wallet.Wallet, *RawTransaction) {
+	t.Helper()
+
+	bc, w := newMaturityChain(t, 1)
+	recipient, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := NewRawTransaction(string(w.Address()), []Payment{{string(recipient.Address()), 10}}, 1, "", &UTXOSet{bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bc, w, raw
+}
+
+func TestRawTransactionSignAndSend(t *testing.T) {
+	bc, w, raw := newTestRawTx(t)
+
+	if unsigned := raw.Unsigned(); len(unsigned) != len(raw.Tx.Inputs) {
+		t.Fatalf("inputs %v of %d are unsigned before signing", unsigned, len(raw.Tx.Inputs))
+	}
+	signed, err := raw.Sign(w.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed != len(raw.Tx.Inputs) || len(raw.Unsigned()) != 0 {
+		t.Fatalf("signed %d inputs, %v are left", signed, raw.Unsigned())
+	}
+	if fee, err := raw.Fee(); err != nil || fee != 1 {
+		t.Errorf("fee is %d (%v), expected 1", fee, err)
+	}
+
+	decoded, err := DeserializeRawTransaction(raw.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Unsigned()) != 0 {
+		t.Error("signatures are lost by the encoding")
+	}
+
+	if err := (Mempool{bc}).Add(&decoded.Tx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSignatureCommitsToSpentValue(t *testing.T) {
+	bc, w, raw := newTestRawTx(t)
+	value := raw.Spent[0].Value
+
+	// a signature over the right value no longer verifies when the value
+	// is changed
+	if _, err := raw.Sign(w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	raw.Spent[0].Value = value + 1000
+	if unsigned := raw.Unsigned(); len(unsigned) != 1 || unsigned[0] != 0 {
+		t.Errorf("input signed for value %d verifies for value %d", value, value+1000)
+	}
+
+	// a signer lied to about the value signs a transaction the chain
+	// rejects, instead of paying a fee it was not shown
+	raw.Spent[0].Value = value / 2
+	if _, err := raw.Sign(w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw.Unsigned()) != 0 {
+		t.Fatal("input signed with the wrong value does not verify against it")
+	}
+
+	err := (Mempool{bc}).Add(&raw.Tx)
+	if _, ok := err.(*TxValidationError); !ok {
+		t.Errorf("transaction signed for a wrong value was not rejected: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package blockchain
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/dgraph-io/badger"
+)
+
+var workPrefix = []byte("work-")
+
+func workKey(blockHash []byte) []byte {
+	return append(append([]byte{}, workPrefix...), blockHash...)
+}
+
+// blockWork returns the expected number of hashes needed to mine a block
+// with the given difficulty
+func blockWork(bits int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits))
+}
+
+// chainWork returns the total work of the chain ending with the given block
+func chainWork(txn *badger.Txn, blockHash []byte) (*big.Int, error) {
+	item, err := txn.Get(workKey(blockHash))
+	if err != nil {
+		return nil, err
+	}
+	work, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(work), nil
+}
+
+// getBlock reads a block, its header and its transactions, as part of the
+// caller's database transaction. It returns ErrBlockNotFound when the
+// block is not stored
+func getBlock(txn *badger.Txn, blockHash []byte) (*Block, error) {
+	header, err := getHeader(txn, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := txn.Get(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	body, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	d := decoder{data: body}
+	block := &Block{BlockHeader: *header, Hash: blockHash, Transactions: d.transactions()}
+
+	return block, d.finish()
+}
+
+// reorganize makes a stored block the tip of the chain. The blocks of the
+// current chain down to the fork point are disconnected and the blocks of
+// the new branch connected, each one validated against the UTXO set as it
+// is at that point. Everything happens in the caller's database
+// transaction, so a branch with an invalid block leaves the chain as it was
+func (bc *Blockchain) reorganize(txn *badger.Txn, newTip *Block) error {
+	var branch, disconnect [][]byte
+
+	// find the fork point walking back from both tips, headers are enough
+	tipHash, forkHash := bc.LastHash, newTip.Hash
+	tip, err := getHeader(txn, tipHash)
+	if err != nil {
+		return err
+	}
+	fork := &newTip.BlockHeader
+
+	for fork.Height > tip.Height {
+		branch = append(branch, forkHash)
+		forkHash = fork.HashPrevBlock
+		if fork, err = getHeader(txn, forkHash); err != nil {
+			return err
+		}
+	}
+	for tip.Height > fork.Height {
+		disconnect = append(disconnect, tipHash)
+		tipHash = tip.HashPrevBlock
+		if tip, err = getHeader(txn, tipHash); err != nil {
+			return err
+		}
+	}
+	for bytes.Compare(tipHash, forkHash) != 0 {
+		disconnect = append(disconnect, tipHash)
+		branch = append(branch, forkHash)
+		tipHash, forkHash = tip.HashPrevBlock, fork.HashPrevBlock
+		if tip, err = getHeader(txn, tipHash); err != nil {
+			return err
+		}
+		if fork, err = getHeader(txn, forkHash); err != nil {
+			return err
+		}
+	}
+
+	for _, blockHash := range disconnect {
+		block, err := getBlock(txn, blockHash)
+		if err != nil {
+			return err
+		}
+		if err := disconnectBlock(txn, block); err != nil {
+			return err
+		}
+	}
+
+	lookup := func(txID []byte, outIdx int) (UnspentOutput, bool, error) {
+		return findOutput(txn, txID, outIdx)
+	}
+
+	for i := len(branch) - 1; i >= 0; i-- {
+		block, err := getBlock(txn, branch[i])
+		if err != nil {
+			return err
+		}
+		if err := validateTransactions(block, bc.CoinbaseMaturity, lookup); err != nil {
+			return err
+		}
+		if err := connectBlock(txn, block); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,325 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"golang-blockchain/wallet"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+// mineOn mines a block with the given transactions on top of prev, which
+// needn't be the tip, and adds it. The coinbase pays w the subsidy and the
+// fees
+func mineOn(t *testing.T, bc *Blockchain, w *wallet.Wallet, prev *Block, fees int, txs ...*Transaction) (*Block, error) {
+	t.Helper()
+
+	bits, err := bc.NextBits(&prev.BlockHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	medianTime, err := bc.MedianTimePast(&prev.BlockHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cbTx, err := CoinbaseTX(string(w.Address()), "", prev.Height+1, fees)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := newBlock(append([]*Transaction{cbTx}, txs...), prev.Hash, prev.Height+1, bits)
+	if block.Time <= medianTime {
+		block.Time = medianTime + 1
+	}
+	if err := block.Mine(context.Background(), MiningOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = bc.AddBlock(block)
+
+	return block, err
+}
+
+// tipBlock returns the block at the tip of the chain
+func tipBlock(t *testing.T, bc *Blockchain) *Block {
+	t.Helper()
+
+	block, err := bc.GetBlock(bc.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &block
+}
+
+// storedUTXO returns the UTXO set as stored in the database
+func storedUTXO(t *testing.T, bc *Blockchain) map[string]map[int]UnspentOutput {
+	t.Helper()
+
+	UTXO := make(map[string]map[int]UnspentOutput)
+	err := UTXOSet{bc}.forEach(func(txID []byte, outIdx int, out UnspentOutput) bool {
+		id := hex.EncodeToString(txID)
+		if UTXO[id] == nil {
+			UTXO[id] = make(map[int]UnspentOutput)
+		}
+		UTXO[id][outIdx] = out
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return UTXO
+}
+
+// checkChain checks that the main chain is made of the given blocks: the
+// tip, the height index, the transaction index and the UTXO set, which
+// must match a scan of the chain. Transactions of the stale blocks must
+// not be found
+func checkChain(t *testing.T, bc *Blockchain, chain []*Block, stale []*Block) {
+	t.Helper()
+
+	tip := chain[len(chain)-1]
+	if bytes.Compare(bc.LastHash, tip.Hash) != 0 {
+		t.Errorf("tip is %x, expected %x", bc.LastHash, tip.Hash)
+	}
+	err := bc.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("lh"))
+		if err != nil {
+			return err
+		}
+		lastHash, err := item.ValueCopy(nil)
+		if err == nil && bytes.Compare(lastHash, tip.Hash) != 0 {
+			t.Errorf("stored tip is %x, expected %x", lastHash, tip.Hash)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for height, block := range chain {
+		blockHash, err := bc.GetBlockHashByHeight(height)
+		if err != nil || bytes.Compare(blockHash, block.Hash) != 0 {
+			t.Errorf("block at height %d is %x (%v), expected %x", height, blockHash, err, block.Hash)
+		}
+		for _, tx := range block.Transactions {
+			_, found, err := bc.LocateTransaction(tx.ID)
+			if err != nil || bytes.Compare(found.Hash, block.Hash) != 0 {
+				t.Errorf("transaction %x is in block %x (%v), expected %x", tx.ID, found.Hash, err, block.Hash)
+			}
+		}
+	}
+	if _, err := bc.GetBlockHashByHeight(len(chain)); err != ErrBlockNotFound {
+		t.Errorf("height %d above the tip is indexed: %v", len(chain), err)
+	}
+
+	for _, block := range stale {
+		for _, tx := range block.Transactions {
+			if _, _, err := bc.LocateTransaction(tx.ID); err != ErrTxNotFound {
+				t.Errorf("transaction %x of stale block %x is found: %v", tx.ID, block.Hash, err)
+			}
+		}
+	}
+
+	scanned, err := bc.FindUTXO()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := storedUTXO(t, bc); !reflect.DeepEqual(stored, scanned) {
+		t.Errorf("UTXO set %v doesn't match the chain %v", stored, scanned)
+	}
+}
+
+// checkHistory checks the heights of the address index entries of w
+func checkHistory(t *testing.T, bc *Blockchain, w *wallet.Wallet, heights ...int) {
+	t.Helper()
+
+	history, err := bc.History(wallet.PublicKeyHash(w.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, entry := range history {
+		got = append(got, entry.Height)
+	}
+	if !reflect.DeepEqual(got, heights) {
+		t.Errorf("history of %s is at heights %v, expected %v", w.Address(), got, heights)
+	}
+}
+
+// checkMempool checks whether the mempool holds exactly the given
+// transaction
+func checkMempool(t *testing.T, bc *Blockchain, tx *Transaction, pending bool) {
+	t.Helper()
+
+	pool := Mempool{bc}
+	has, err := pool.Has(tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := pool.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has != pending || (pending && count != 1) || (!pending && count != 0) {
+		t.Errorf("mempool holds %d transactions, pending %v, expected %v", count, has, pending)
+	}
+}
+
+func TestReorganizeAndBack(t *testing.T) {
+	bc, miner := newMaturityChain(t, 1)
+	if err := bc.EnableTxIndex(); err != nil {
+		t.Fatal(err)
+	}
+	forker, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := tipBlock(t, bc)
+	a1, err := mineOn(t, bc, miner, genesis, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := NewTransaction(miner, []Payment{{string(recipient.Address()), 10}}, 1, "", &UTXOSet{bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (Mempool{bc}).Add(tx); err != nil {
+		t.Fatal(err)
+	}
+	a2, err := mineOn(t, bc, miner, a1, 1, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a3, err := mineOn(t, bc, miner, a2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mainChain := []*Block{genesis, a1, a2, a3}
+	checkChain(t, bc, mainChain, nil)
+	checkHistory(t, bc, recipient, 2)
+	checkMempool(t, bc, tx, false)
+
+	// a branch from a1 takes over once it has more work, three blocks
+	// against two
+	var branch []*Block
+	prev := a1
+	for i := 0; i < 3; i++ {
+		block, err := mineOn(t, bc, forker, prev, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 && bytes.Compare(bc.LastHash, a3.Hash) != 0 {
+			t.Fatalf("branch of %d blocks replaced a chain with as much work", i+1)
+		}
+		branch = append(branch, block)
+		prev = block
+	}
+
+	checkChain(t, bc, append([]*Block{genesis, a1}, branch...), []*Block{a2, a3})
+	checkHistory(t, bc, recipient)
+	checkHistory(t, bc, forker, 2, 3, 4)
+	checkMempool(t, bc, tx, true)
+	if err := bc.VerifyChain(); err != nil {
+		t.Error(err)
+	}
+
+	// and the former chain takes over again, confirming tx again
+	a4, err := mineOn(t, bc, miner, a3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(bc.LastHash, branch[2].Hash) != 0 {
+		t.Fatal("chain with as much work replaced the tip")
+	}
+	a5, err := mineOn(t, bc, miner, a4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkChain(t, bc, append(mainChain, a4, a5), branch)
+	checkHistory(t, bc, recipient, 2)
+	checkHistory(t, bc, forker)
+	checkMempool(t, bc, tx, false)
+	if err := bc.VerifyChain(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReorganizeToInvalidBranch(t *testing.T) {
+	bc, miner := newMaturityChain(t, 1)
+	if err := bc.EnableTxIndex(); err != nil {
+		t.Fatal(err)
+	}
+	other, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := tipBlock(t, bc)
+	a1, err := mineOn(t, bc, miner, genesis, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := mineOn(t, bc, other, a1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a pending transaction, and one spending the coinbase of a2, which
+	// doesn't exist on a branch from a1
+	pending, err := NewTransaction(miner, []Payment{{string(other.Address()), 10}}, 1, "", &UTXOSet{bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (Mempool{bc}).Add(pending); err != nil {
+		t.Fatal(err)
+	}
+	spendsA2, err := NewTransaction(other, []Payment{{string(miner.Address()), 10}}, 0, "", &UTXOSet{bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mainChain := []*Block{genesis, a1, a2}
+	UTXO := storedUTXO(t, bc)
+
+	b2, err := mineOn(t, bc, miner, a1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b3, err := mineOn(t, bc, miner, b2, 0, spendsA2)
+	if _, ok := err.(*BlockValidationError); !ok {
+		t.Fatalf("branch with an invalid block was not rejected: %v", err)
+	}
+
+	checkChain(t, bc, mainChain, []*Block{b2, b3})
+	checkHistory(t, bc, other, 2)
+	checkMempool(t, bc, pending, true)
+	if stored := storedUTXO(t, bc); !reflect.DeepEqual(stored, UTXO) {
+		t.Error("UTXO set changed")
+	}
+	if known, err := bc.HasBlock(b3.Hash); err != nil || known {
+		t.Errorf("invalid block is stored: %v", err)
+	}
+	if err := bc.VerifyChain(); err != nil {
+		t.Error(err)
+	}
+
+	// the tip is still extended normally
+	if _, err := mineOn(t, bc, miner, a2, 1, pending); err != nil {
+		t.Fatal(err)
+	}
+	if height, err := bc.GetBestHeight(); err != nil || height != 3 {
+		t.Errorf("height is %d (%v), expected 3", height, err)
+	}
+	checkMempool(t, bc, pending, false)
+}
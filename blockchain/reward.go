@@ -0,0 +1,74 @@
+package blockchain
+
+const (
+	// initialSubsidy is the amount of coins the coinbase of the first
+	// blocks may create
+	initialSubsidy = 100
+	// halvingInterval is the number of blocks after which the subsidy is
+	// halved
+	halvingInterval = 1000
+	// MaxSupply caps the coins ever created by coinbases, the subsidy is
+	// cut when a block would go beyond it
+	MaxSupply = 190000
+	// DefaultCoinbaseMaturity is the coinbase maturity of the chains
+	// created without choosing one
+	DefaultCoinbaseMaturity = 10
+)
+
+// addValue adds an amount of coins to a total in 0..MaxSupply. It reports
+// false when the amount or the new total is outside that range, which no
+// output or sum of outputs can be, so sums of values never overflow
+func addValue(total, value int) (int, bool) {
+	if value < 0 || value > MaxSupply || total+value > MaxSupply {
+		return total, false
+	}
+
+	return total + value, true
+}
+
+// halvedSubsidy returns the subsidy of the block at the given height
+// following the halving schedule alone
+func halvedSubsidy(height int) int {
+	halvings := height / halvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+
+	return initialSubsidy >> uint(halvings)
+}
+
+// Supply returns the number of coins the coinbases of the blocks up to
+// the given height may create together, following the halving schedule
+// and capped by the maximum supply
+func Supply(height int) int {
+	total := 0
+
+	for era := 0; era*halvingInterval <= height; era++ {
+		subsidy := halvedSubsidy(era * halvingInterval)
+		if subsidy == 0 {
+			break
+		}
+
+		blocks := height + 1 - era*halvingInterval
+		if blocks > halvingInterval {
+			blocks = halvingInterval
+		}
+		total += blocks * subsidy
+
+		if total >= MaxSupply {
+			return MaxSupply
+		}
+	}
+
+	return total
+}
+
+// BlockSubsidy returns the number of coins the coinbase of the block at
+// the given height may create on top of the fees of the block
+func BlockSubsidy(height int) int {
+	if height == 0 {
+		return Supply(0)
+	}
+
+	return Supply(height) - Supply(height-1)
+}
@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"golang-blockchain/wallet"
+	"testing"
+)
+
+// capHeight is the height of the last block creating coins: the first
+// four eras create 187000 coins and the fifth one the remaining 3000 at
+// 6 coins a block
+const capHeight = 4*halvingInterval + 499
+
+func TestBlockSubsidy(t *testing.T) {
+	tests := []struct {
+		height  int
+		subsidy int
+		supply  int
+	}{
+		{0, 100, 100},
+		{1, 100, 200},
+		{999, 100, 100000},
+		{1000, 50, 100050},
+		{1001, 50, 100100},
+		{1999, 50, 150000},
+		{2000, 25, 150025},
+		{3000, 12, 175012},
+		{4000, 6, 187006},
+		// the cap cuts the subsidy of the next block to 0
+		{capHeight, 6, MaxSupply},
+		{capHeight + 1, 0, MaxSupply},
+		{5000, 0, MaxSupply},
+		{63 * halvingInterval, 0, MaxSupply},
+	}
+	for _, test := range tests {
+		if subsidy := BlockSubsidy(test.height); subsidy != test.subsidy {
+			t.Errorf("subsidy at height %d is %d, expected %d", test.height, subsidy, test.subsidy)
+		}
+		if supply := Supply(test.height); supply != test.supply {
+			t.Errorf("supply at height %d is %d, expected %d", test.height, supply, test.supply)
+		}
+	}
+	if halved := halvedSubsidy(capHeight + 1); halved != 6 {
+		t.Errorf("halving schedule gives %d at height %d, expected 6", halved, capHeight+1)
+	}
+
+	total := 0
+	for height := 0; height <= 5*halvingInterval; height++ {
+		total += BlockSubsidy(height)
+		if total != Supply(height) {
+			t.Fatalf("subsidies up to height %d add up to %d, the supply is %d", height, total, Supply(height))
+		}
+	}
+	if total != MaxSupply {
+		t.Errorf("subsidies add up to %d, expected %d", total, MaxSupply)
+	}
+}
+
+func TestCoinbaseValue(t *testing.T) {
+	w, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	noOutputs := func(txID []byte, outIdx int) (UnspentOutput, bool, error) {
+		return UnspentOutput{}, false, nil
+	}
+
+	tests := []struct {
+		height int
+		// extra is paid by the coinbase on top of the subsidy
+		extra int
+		ok    bool
+	}{
+		{999, 0, true},
+		{999, 1, false},
+		{1000, 0, true},
+		{1000, 1, false},
+		{1001, 0, true},
+		{1001, 1, false},
+		{capHeight + 1, 0, true},
+		{capHeight + 1, 1, false},
+	}
+	for _, test := range tests {
+		cbTx, err := CoinbaseTX(string(w.Address()), "", test.height, test.extra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := newBlock([]*Transaction{cbTx}, []byte{}, test.height, initialBits)
+
+		err = validateTransactions(block, DefaultCoinbaseMaturity, noOutputs)
+		if test.ok && err != nil {
+			t.Errorf("coinbase paying %d at height %d: %s", cbTx.Outputs[0].Value, test.height, err)
+		}
+		if _, rejected := err.(*BlockValidationError); !test.ok && !rejected {
+			t.Errorf("coinbase paying %d at height %d was not rejected: %v", cbTx.Outputs[0].Value, test.height, err)
+		}
+	}
+}
+
+func TestCoinbaseCannotPayMoreThanSubsidyAndFees(t *testing.T) {
+	bc, w, raw := newTestRawTx(t)
+	if _, err := raw.Sign(w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	fee, err := raw.Fee()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := tipBlock(t, bc)
+
+	_, err = mineOn(t, bc, w, genesis, fee+1, &raw.Tx)
+	if _, ok := err.(*BlockValidationError); !ok {
+		t.Errorf("coinbase paying the subsidy, fees of %d and 1 more was not rejected: %v", fee, err)
+	}
+	if _, err := mineOn(t, bc, w, genesis, fee, &raw.Tx); err != nil {
+		t.Errorf("coinbase paying the subsidy and fees of %d: %s", fee, err)
+	}
+	if height, err := bc.GetBestHeight(); err != nil || height != 1 {
+		t.Errorf("height is %d (%v), expected 1", height, err)
+	}
+}
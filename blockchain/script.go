@@ -0,0 +1,409 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"golang-blockchain/wallet"
+	"math/big"
+	"strings"
+)
+
+// Outputs are locked with a script and inputs unlock them with another
+// one. To spend an output, the unlocking script of the input is run, then
+// the locking script of the output on the same stack, and the spend is
+// valid when the top of the stack is true. A pay-to-pubkey-hash output is
+// locked with
+//
+//	OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+//
+// and unlocked with <signature> <pubKey>.
+
+// Script opcodes, 0x01 to 0x4b push that many bytes and OP_1 to OP_16
+// push the numbers 1 to 16
+const (
+	op0              byte = 0x00
+	opPushData1      byte = 0x4c
+	opPushData2      byte = 0x4d
+	op1              byte = 0x51
+	op16             byte = 0x60
+	opVerify         byte = 0x69
+	opReturn         byte = 0x6a
+	opDrop           byte = 0x75
+	opDup            byte = 0x76
+	opEqual          byte = 0x87
+	opEqualVerify    byte = 0x88
+	opSHA256         byte = 0xa8
+	opHash160        byte = 0xa9
+	opCheckSig       byte = 0xac
+	opCheckSigVerify byte = 0xad
+)
+
+const (
+	// maxScriptSize is the size limit of a script
+	maxScriptSize = 10000
+	// maxStackSize is the number of items the stack may hold
+	maxStackSize = 1000
+	// maxPushSize is the size limit of a pushed item
+	maxPushSize = 520
+)
+
+var opcodeNames = map[byte]string{
+	op0:              "OP_0",
+	opVerify:         "OP_VERIFY",
+	opReturn:         "OP_RETURN",
+	opDrop:           "OP_DROP",
+	opDup:            "OP_DUP",
+	opEqual:          "OP_EQUAL",
+	opEqualVerify:    "OP_EQUALVERIFY",
+	opSHA256:         "OP_SHA256",
+	opHash160:        "OP_HASH160",
+	opCheckSig:       "OP_CHECKSIG",
+	opCheckSigVerify: "OP_CHECKSIGVERIFY",
+}
+
+// Script is a program locking an output or unlocking it in an input
+type Script []byte
+
+// scriptBuilder appends opcodes and pushes to a script
+type scriptBuilder struct {
+	script Script
+}
+
+func (b *scriptBuilder) op(opcode byte) *scriptBuilder {
+	b.script = append(b.script, opcode)
+	return b
+}
+
+// push appends the shortest push of data
+func (b *scriptBuilder) push(data []byte) *scriptBuilder {
+	switch n := len(data); {
+	case n < int(opPushData1):
+		b.script = append(b.script, byte(n))
+	case n <= 0xff:
+		b.script = append(b.script, opPushData1, byte(n))
+	default:
+		var size [2]byte
+		binary.LittleEndian.PutUint16(size[:], uint16(n))
+		b.script = append(append(b.script, opPushData2), size[:]...)
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+// P2PKHScript returns the script locking an output to the owner of the
+// public key with the given hash
+func P2PKHScript(pubKeyHash []byte) Script {
+	var b scriptBuilder
+	b.op(opDup).op(opHash160).push(pubKeyHash).op(opEqualVerify).op(opCheckSig)
+
+	return b.script
+}
+
+// P2PKHUnlockScript returns the script spending a pay-to-pubkey-hash
+// output with a signature and the public key
+func P2PKHUnlockScript(signature, pubKey []byte) Script {
+	var b scriptBuilder
+	b.push(signature).push(pubKey)
+
+	return b.script
+}
+
+// parsedOp is an opcode with the data it pushes
+type parsedOp struct {
+	opcode byte
+	data   []byte
+}
+
+// parse splits a script into its opcodes, it fails on truncated pushes
+func (s Script) parse() ([]parsedOp, error) {
+	var ops []parsedOp
+
+	for i := 0; i < len(s); {
+		opcode := s[i]
+		i++
+
+		n := -1
+		switch {
+		case opcode > op0 && opcode < opPushData1:
+			n = int(opcode)
+		case opcode == opPushData1:
+			if i+1 > len(s) {
+				return nil, ErrScriptFailed
+			}
+			n = int(s[i])
+			i++
+		case opcode == opPushData2:
+			if i+2 > len(s) {
+				return nil, ErrScriptFailed
+			}
+			n = int(binary.LittleEndian.Uint16(s[i:]))
+			i += 2
+		}
+
+		if n < 0 {
+			ops = append(ops, parsedOp{opcode: opcode})
+			continue
+		}
+		if i+n > len(s) {
+			return nil, ErrScriptFailed
+		}
+		ops = append(ops, parsedOp{opcode, s[i : i+n]})
+		i += n
+	}
+
+	return ops, nil
+}
+
+// isPush reports whether the opcode only pushes data
+func isPush(opcode byte) bool {
+	return opcode <= opPushData2 || opcode >= op1 && opcode <= op16
+}
+
+// IsPushOnly reports whether the script only pushes data, as unlocking
+// scripts must
+func (s Script) IsPushOnly() bool {
+	ops, err := s.parse()
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if !isPush(op.opcode) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PubKeyHash returns the public key hash of a pay-to-pubkey-hash script,
+// or false for any other script
+func (s Script) PubKeyHash() ([]byte, bool) {
+	ops, err := s.parse()
+	if err != nil || len(ops) != 5 {
+		return nil, false
+	}
+	if ops[0].opcode != opDup || ops[1].opcode != opHash160 || len(ops[2].data) != 20 ||
+		ops[3].opcode != opEqualVerify || ops[4].opcode != opCheckSig {
+		return nil, false
+	}
+
+	return ops[2].data, true
+}
+
+// String disassembles the script, pushes are shown in hex
+func (s Script) String() string {
+	ops, err := s.parse()
+	if err != nil {
+		return fmt.Sprintf("[malformed %x]", []byte(s))
+	}
+
+	var words []string
+	for _, op := range ops {
+		switch {
+		case op.opcode > op0 && op.opcode <= opPushData2:
+			words = append(words, fmt.Sprintf("%x", op.data))
+		case op.opcode >= op1 && op.opcode <= op16:
+			words = append(words, fmt.Sprintf("OP_%d", op.opcode-op1+1))
+		case opcodeNames[op.opcode] != "":
+			words = append(words, opcodeNames[op.opcode])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_%x", op.opcode))
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// signatureChecker verifies the signatures of opCheckSig, the hash they
+// sign depends on the transaction and input being spent
+type signatureChecker func(signature, pubKey []byte) bool
+
+// stack is the stack of a running script
+type stack [][]byte
+
+func (st *stack) push(item []byte) error {
+	if len(*st) >= maxStackSize {
+		return ErrScriptFailed
+	}
+	*st = append(*st, item)
+	return nil
+}
+
+func (st *stack) pop() ([]byte, error) {
+	if len(*st) == 0 {
+		return nil, ErrScriptFailed
+	}
+	item := (*st)[len(*st)-1]
+	*st = (*st)[:len(*st)-1]
+	return item, nil
+}
+
+// isTrue reports whether a stack item counts as true, any item with a non
+// zero byte
+func isTrue(item []byte) bool {
+	for _, b := range item {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func boolItem(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
+
+// execute runs a script on the stack
+func (s Script) execute(st *stack, checkSig signatureChecker) error {
+	if len(s) > maxScriptSize {
+		return ErrScriptFailed
+	}
+	ops, err := s.parse()
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		if op.opcode <= opPushData2 {
+			if len(op.data) > maxPushSize {
+				return ErrScriptFailed
+			}
+			if err := st.push(op.data); err != nil {
+				return err
+			}
+			continue
+		}
+		if op.opcode >= op1 && op.opcode <= op16 {
+			if err := st.push([]byte{op.opcode - op1 + 1}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch op.opcode {
+		case opDup:
+			if len(*st) == 0 {
+				return ErrScriptFailed
+			}
+			if err := st.push((*st)[len(*st)-1]); err != nil {
+				return err
+			}
+
+		case opDrop:
+			if _, err := st.pop(); err != nil {
+				return err
+			}
+
+		case opSHA256, opHash160:
+			item, err := st.pop()
+			if err != nil {
+				return err
+			}
+			if op.opcode == opSHA256 {
+				hash := sha256.Sum256(item)
+				st.push(hash[:])
+			} else {
+				st.push(wallet.PublicKeyHash(item))
+			}
+
+		case opEqual, opEqualVerify, opCheckSig, opCheckSigVerify:
+			b, err := st.pop()
+			if err != nil {
+				return err
+			}
+			a, err := st.pop()
+			if err != nil {
+				return err
+			}
+
+			var result bool
+			if op.opcode == opEqual || op.opcode == opEqualVerify {
+				result = bytes.Compare(a, b) == 0
+			} else {
+				result = checkSig(a, b)
+			}
+
+			if op.opcode == opEqualVerify || op.opcode == opCheckSigVerify {
+				if !result {
+					return ErrScriptFailed
+				}
+			} else {
+				st.push(boolItem(result))
+			}
+
+		case opVerify:
+			item, err := st.pop()
+			if err != nil {
+				return err
+			}
+			if !isTrue(item) {
+				return ErrScriptFailed
+			}
+
+		default:
+			// opReturn and unknown opcodes make the output unspendable
+			return ErrScriptFailed
+		}
+	}
+
+	return nil
+}
+
+// verifyScript runs the unlocking script of an input and then the locking
+// script of the output it spends, it returns ErrScriptFailed unless the
+// spend is valid
+func verifyScript(unlock, lock Script, checkSig signatureChecker) error {
+	if !unlock.IsPushOnly() {
+		return ErrScriptFailed
+	}
+
+	var st stack
+	if err := unlock.execute(&st, checkSig); err != nil {
+		return err
+	}
+	if err := lock.execute(&st, checkSig); err != nil {
+		return err
+	}
+
+	top, err := st.pop()
+	if err != nil || !isTrue(top) {
+		return ErrScriptFailed
+	}
+
+	return nil
+}
+
+// verifySignature checks an ECDSA signature, r and s concatenated, of hash
+// by a public key, X and Y concatenated
+func verifySignature(hash, signature, pubKey []byte) bool {
+	if len(signature) == 0 || len(pubKey) == 0 {
+		return false
+	}
+
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:(keyLen / 2)])
+	y.SetBytes(pubKey[(keyLen / 2):])
+
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(&x, &y) {
+		return false
+	}
+	rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+
+	return ecdsa.Verify(&rawPubKey, hash, &r, &s)
+}
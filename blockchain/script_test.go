@@ -0,0 +1,258 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"golang-blockchain/wallet"
+	"testing"
+)
+
+// pushes returns a script of n bytes after the given prefix
+func pushes(prefix []byte, n int) Script {
+	return append(append(Script{}, prefix...), make([]byte, n)...)
+}
+
+// repeat returns a script made of n times the opcode
+func repeat(opcode byte, n int) Script {
+	return Script(bytes.Repeat([]byte{opcode}, n))
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		script Script
+		ok     bool
+		// ops is the number of opcodes and last the data pushed by the
+		// last one
+		ops  int
+		last int
+	}{
+		{"empty", Script{}, true, 0, 0},
+		{"OP_0", Script{op0}, true, 1, 0},
+		{"push of 1", Script{0x01, 0xaa}, true, 1, 1},
+		{"push of 75", pushes([]byte{0x4b}, 75), true, 1, 75},
+		{"truncated push", Script{0x02, 0xaa}, false, 0, 0},
+		{"push without data", Script{0x01}, false, 0, 0},
+		{"PUSHDATA1", pushes([]byte{opPushData1, 0x02}, 2), true, 1, 2},
+		{"PUSHDATA1 of 255", pushes([]byte{opPushData1, 0xff}, 255), true, 1, 255},
+		{"PUSHDATA1 without size", Script{opPushData1}, false, 0, 0},
+		{"truncated PUSHDATA1", pushes([]byte{opPushData1, 0x03}, 2), false, 0, 0},
+		{"PUSHDATA2", pushes([]byte{opPushData2, 0x00, 0x01}, 256), true, 1, 256},
+		{"PUSHDATA2 with a short size", Script{opPushData2, 0x01}, false, 0, 0},
+		{"truncated PUSHDATA2", pushes([]byte{opPushData2, 0x00, 0x01}, 255), false, 0, 0},
+		{"opcodes and pushes", Script{opDup, 0x01, 0xaa, opDrop, op1}, true, 4, 0},
+	}
+	for _, test := range tests {
+		ops, err := test.script.parse()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: parse error %v, expected success %v", test.name, err, test.ok)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if len(ops) != test.ops {
+			t.Errorf("%s: %d opcodes, expected %d", test.name, len(ops), test.ops)
+			continue
+		}
+		if len(ops) > 0 && len(ops[len(ops)-1].data) != test.last {
+			t.Errorf("%s: last opcode pushes %d bytes, expected %d", test.name, len(ops[len(ops)-1].data), test.last)
+		}
+	}
+}
+
+func TestPushIsShortest(t *testing.T) {
+	tests := []struct {
+		size   int
+		opcode byte
+	}{
+		{0, op0},
+		{1, 0x01},
+		{75, 0x4b},
+		{76, opPushData1},
+		{255, opPushData1},
+		{256, opPushData2},
+		{maxPushSize, opPushData2},
+	}
+	for _, test := range tests {
+		data := bytes.Repeat([]byte{0xab}, test.size)
+		var b scriptBuilder
+		script := b.push(data).script
+
+		if script[0] != test.opcode {
+			t.Errorf("push of %d bytes starts with %x, expected %x", test.size, script[0], test.opcode)
+		}
+		ops, err := script.parse()
+		if err != nil || len(ops) != 1 || bytes.Compare(ops[0].data, data) != 0 {
+			t.Errorf("push of %d bytes parses to %v (%v)", test.size, ops, err)
+		}
+	}
+}
+
+func TestIsPushOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		script Script
+		want   bool
+	}{
+		{"empty", Script{}, true},
+		{"OP_0", Script{op0}, true},
+		{"OP_1 and OP_16", Script{op1, op16}, true},
+		{"PUSHDATA1", Script{opPushData1, 0x01, 0xaa}, true},
+		{"unlocking script", P2PKHUnlockScript([]byte{1, 2}, []byte{3, 4}), true},
+		{"truncated push", Script{0x02, 0xaa}, false},
+		{"OP_DUP", Script{0x01, 0xaa, opDup}, false},
+		{"OP_RETURN", Script{opReturn}, false},
+		{"locking script", P2PKHScript(make([]byte, 20)), false},
+	}
+	for _, test := range tests {
+		if got := test.script.IsPushOnly(); got != test.want {
+			t.Errorf("%s: IsPushOnly() = %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	var b scriptBuilder
+	largest := b.push(make([]byte, maxPushSize)).script
+	b = scriptBuilder{}
+	tooLarge := b.push(make([]byte, maxPushSize+1)).script
+	longest := Script{op1}
+	for len(longest) < maxScriptSize-1 {
+		longest = append(longest, opDup, opDrop)
+	}
+	longest = append(longest, op1)
+
+	tests := []struct {
+		name   string
+		script Script
+		ok     bool
+	}{
+		{"full stack", repeat(op1, maxStackSize), true},
+		{"stack overflow", repeat(op1, maxStackSize+1), false},
+		{"OP_DUP on a full stack", append(repeat(op1, maxStackSize), opDup), false},
+		{"largest push", largest, true},
+		{"push too large", tooLarge, false},
+		{"longest script", longest, true},
+		{"script too long", append(longest, op1), false},
+		{"pop from the empty stack", Script{opDrop}, false},
+	}
+	for _, test := range tests {
+		var st stack
+		err := test.script.execute(&st, nil)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: execute error %v, expected success %v", test.name, err, test.ok)
+		}
+	}
+}
+
+func TestUnspendableOpcodes(t *testing.T) {
+	for _, lock := range []Script{
+		{opReturn},
+		{opReturn, 0x01, 0xaa},
+		{op1, opReturn},
+		{op1, 0x61},
+		{op1, 0xff},
+		{op1, opCheckSig + 0x10},
+	} {
+		var st stack
+		if err := lock.execute(&st, nil); err != ErrScriptFailed {
+			t.Errorf("%s: execute error %v, expected ErrScriptFailed", lock, err)
+		}
+		if err := verifyScript(Script{op1}, lock, nil); err != ErrScriptFailed {
+			t.Errorf("%s: verifyScript error %v, expected ErrScriptFailed", lock, err)
+		}
+	}
+}
+
+func TestP2PKHScriptRoundTrip(t *testing.T) {
+	w, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	lock := P2PKHScript(pubKeyHash)
+
+	got, ok := lock.PubKeyHash()
+	if !ok || bytes.Compare(got, pubKeyHash) != 0 {
+		t.Errorf("PubKeyHash() = %x, %v, expected %x", got, ok, pubKeyHash)
+	}
+	if disassembly := lock.String(); disassembly != "OP_DUP OP_HASH160 "+hex.EncodeToString(pubKeyHash)+" OP_EQUALVERIFY OP_CHECKSIG" {
+		t.Errorf("script disassembles to %s", disassembly)
+	}
+
+	for _, script := range []Script{
+		{},
+		P2PKHScript(pubKeyHash[:19]),
+		append(P2PKHScript(pubKeyHash), opDrop),
+		lock[:len(lock)-1],
+		P2PKHUnlockScript(pubKeyHash, w.PublicKey),
+	} {
+		if got, ok := script.PubKeyHash(); ok {
+			t.Errorf("%s is taken for a pay-to-pubkey-hash script of %x", script, got)
+		}
+	}
+}
+
+// signHash returns the signature of a hash by the key, in the format of
+// signInput
+func signHash(t *testing.T, privateKey *ecdsa.PrivateKey, hash []byte) []byte {
+	t.Helper()
+
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(padBytes(r.Bytes(), 32), padBytes(s.Bytes(), 32)...)
+}
+
+func TestP2PKHSpend(t *testing.T) {
+	owner, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := wallet.MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256([]byte("spending transaction"))
+	otherHash := sha256.Sum256([]byte("another transaction"))
+	checkSig := func(signature, pubKey []byte) bool {
+		return verifySignature(hash[:], signature, pubKey)
+	}
+	lock := P2PKHScript(wallet.PublicKeyHash(owner.PublicKey))
+
+	signature := signHash(t, &owner.PrivateKey, hash[:])
+	tampered := append([]byte{}, signature...)
+	tampered[10] ^= 1
+
+	tests := []struct {
+		name   string
+		unlock Script
+		ok     bool
+	}{
+		{"owner", P2PKHUnlockScript(signature, owner.PublicKey), true},
+		{"other key", P2PKHUnlockScript(signHash(t, &other.PrivateKey, hash[:]), other.PublicKey), false},
+		{"signature of another key", P2PKHUnlockScript(signHash(t, &other.PrivateKey, hash[:]), owner.PublicKey), false},
+		{"signature of another hash", P2PKHUnlockScript(signHash(t, &owner.PrivateKey, otherHash[:]), owner.PublicKey), false},
+		{"tampered signature", P2PKHUnlockScript(tampered, owner.PublicKey), false},
+		{"no signature", P2PKHUnlockScript(nil, owner.PublicKey), false},
+		{"no public key", P2PKHUnlockScript(signature, nil), false},
+		{"empty", Script{}, false},
+		{"not push only", append(P2PKHUnlockScript(signature, owner.PublicKey), opDup, opDrop), false},
+	}
+	for _, test := range tests {
+		err := verifyScript(test.unlock, lock, checkSig)
+		if test.ok && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if !test.ok && err != ErrScriptFailed {
+			t.Errorf("%s: verifyScript error %v, expected ErrScriptFailed", test.name, err)
+		}
+	}
+}
@@ -1,17 +1,13 @@
 package blockchain
 
 import (
-	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"golang-blockchain/wallet"
-	"log"
-	"math/big"
 	"strings"
 )
 
@@ -22,17 +18,22 @@ type Transaction struct {
 	Inputs  []TXInput
 }
 
-// Serialize returns a serialized Transaction
+// Serialize returns the canonical encoding of a transaction
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	var e encoder
+	e.transaction(&tx)
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
-	}
+	return e.buf.Bytes()
+}
 
-	return encoded.Bytes()
+// DeserializeTransaction decodes a transaction, it returns
+// ErrMalformedData or ErrUnknownVersion for data that is not a canonical
+// encoding
+func DeserializeTransaction(data []byte) (Transaction, error) {
+	d := decoder{data: data}
+	tx := d.transaction()
+
+	return tx, d.finish()
 }
 
 // Hash creates a hash of transaction
@@ -47,74 +48,142 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
-// NewTransaction creates a new transaction
-func NewTransaction(from, to string, amount int, bc *Blockchain) *Transaction {
+// txFee returns what a transaction pays to the miner, the value of the
+// outputs spent by its inputs minus the value of its own outputs. Each sum
+// must stay within the maximum supply and the fee can't be negative
+func txFee(tx *Transaction, spent []TXOutput) (int, error) {
+	var ok bool
+	inputValue, outputValue := 0, 0
+
+	for _, out := range spent {
+		if inputValue, ok = addValue(inputValue, out.Value); !ok {
+			return 0, errors.New("spent outputs are worth less than 0 or more than the maximum supply")
+		}
+	}
+	for _, out := range tx.Outputs {
+		if outputValue, ok = addValue(outputValue, out.Value); !ok {
+			return 0, errors.New("outputs pay less than 0 or more than the maximum supply")
+		}
+	}
+	if outputValue > inputValue {
+		return 0, errors.New("transaction spends more than its inputs, its fee would be negative")
+	}
+
+	return inputValue - outputValue, nil
+}
+
+// computeID returns the ID the transaction must have: the hash of its
+// content before the inputs are signed. The data of a coinbase is kept
+func (tx *Transaction) computeID() []byte {
+	if tx.IsCoinbase() {
+		return tx.Hash()
+	}
+
+	txCopy := tx.TrimmedCopy()
+
+	return txCopy.Hash()
+}
+
+// Payment is an amount of coins paid to an address
+type Payment struct {
+	Address string
+	Amount  int
+}
+
+// NewTransaction creates a new transaction paying every payment and a fee
+// to the miner from the wallet's outputs. What is left over goes back to
+// changeAddress, or to the wallet's own address when changeAddress is
+// empty. It returns ErrInsufficientFunds when the wallet can't pay for it
+func NewTransaction(w *wallet.Wallet, payments []Payment, fee int, changeAddress string, UTXO *UTXOSet) (*Transaction, error) {
+	tx, err := newUnsignedTransaction(string(w.Address()), payments, fee, changeAddress, UTXO)
+	if err != nil {
+		return nil, err
+	}
+
+	err = UTXO.Blockchain.SignTransaction(tx, w.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// newUnsignedTransaction builds the transaction of NewTransaction from the
+// outputs of the from address, without signing its inputs
+func newUnsignedTransaction(from string, payments []Payment, fee int, changeAddress string, UTXO *UTXOSet) (*Transaction, error) {
 	var inputs []TXInput
 	var outputs []TXOutput
 
-	wallets, err := wallet.CreateWallets()
-	if err != nil {
-		log.Panic(err)
+	amount := fee
+	for _, payment := range payments {
+		if !wallet.ValidateAddress(payment.Address) {
+			return nil, ErrInvalidAddress
+		}
+		amount += payment.Amount
+	}
+	if changeAddress != "" && !wallet.ValidateAddress(changeAddress) {
+		return nil, ErrInvalidAddress
 	}
-	w := wallets.GetWallet(from)
-	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
 
-	accumulated, validOutputs := bc.FindSpendableOutputs(pubKeyHash, amount)
+	accumulated, validOutputs, err := UTXO.FindSpendableOutputs(addressPubKeyHash(from), amount)
+	if err != nil {
+		return nil, err
+	}
 
 	if accumulated < amount {
-		log.Panic("Not enough funds")
+		return nil, ErrInsufficientFunds
 	}
 
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
 		for _, out := range outs {
-			input := TXInput{txID, out, nil, w.PublicKey}
+			input := TXInput{txID, out, nil}
 			inputs = append(inputs, input)
 		}
 	}
 
-	outputs = append(outputs, *NewTXOutput(amount, to))
+	for _, payment := range payments {
+		outputs = append(outputs, *NewTXOutput(payment.Amount, payment.Address))
+	}
 	if accumulated > amount {
-		outputs = append(outputs, *NewTXOutput(accumulated-amount, to))
+		if changeAddress == "" {
+			changeAddress = from
+		}
+		outputs = append(outputs, *NewTXOutput(accumulated-amount, changeAddress))
 	}
 
 	tx := Transaction{nil, outputs, inputs}
 	tx.ID = tx.Hash()
-	bc.SignTransaction(&tx, w.PrivateKey)
 
-	return &tx
+	return &tx, nil
 }
 
-// CoinbaseTX creates a new coinbase transaction
-func CoinbaseTX(to, data string) *Transaction {
+// CoinbaseTX creates a new coinbase transaction paying the subsidy of the
+// block at the given height and the fees of the block's other transactions
+func CoinbaseTX(to, data string, height, fees int) (*Transaction, error) {
 	if data == "" {
-		data = fmt.Sprintf("Coin to %s", to)
+		randData := make([]byte, 24)
+		_, err := rand.Read(randData)
+		if err != nil {
+			return nil, err
+		}
+		data = fmt.Sprintf("%x", randData)
 	}
-	txinput := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txoutput := NewTXOutput(100, to)
+	txinput := TXInput{[]byte{}, -1, []byte(data)}
+	txoutput := NewTXOutput(BlockSubsidy(height)+fees, to)
 	transaction := Transaction{nil, []TXOutput{*txoutput}, []TXInput{txinput}}
 	transaction.SetID()
 
-	return &transaction
+	return &transaction, nil
 }
 
 // SetID sets id to transaction
 func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
-
-	encode := gob.NewEncoder(&encoded)
-	err := encode.Encode(tx)
-	if err != nil {
-		log.Panic()
-	}
-
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
+	tx.ID = tx.Hash()
 }
 
 // IsCoinbase is used to chech transaction
@@ -122,93 +191,146 @@ func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-// Sign is used to sign transaction
-func (tx *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+// Sign is used to sign transaction, prevTXs must hold the transactions
+// spent by its inputs or ErrTxNotFound is returned. Every input gets the
+// script unlocking a pay-to-pubkey-hash output of the key
+func (tx *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+	if err := checkPrevTXs(tx, prevTXs); err != nil {
+		return err
+	}
+
+	for inID, in := range tx.Inputs {
+		prevTX := prevTXs[hex.EncodeToString(in.ID)]
+		if err := tx.signInput(inID, &privateKey, prevTX.Outputs[in.Out]); err != nil {
+			return err
 		}
 	}
 
+	return nil
+}
+
+// signInput gives the input at inIdx the script unlocking a
+// pay-to-pubkey-hash output of the key, spent is the output it spends
+func (tx *Transaction) signInput(inIdx int, privateKey *ecdsa.PrivateKey, spent TXOutput) error {
+	hash := tx.signatureHash(inIdx, spent)
+
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash)
+	if err != nil {
+		return err
+	}
+	// r and s are padded to the same length so that the signature splits
+	// in the middle
+	signature := append(padBytes(r.Bytes(), 32), padBytes(s.Bytes(), 32)...)
+
+	tx.Inputs[inIdx].ScriptSig = P2PKHUnlockScript(signature, wallet.PublicKeyBytes(&privateKey.PublicKey))
+
+	return nil
+}
+
+// padBytes left pads a big-endian number with zeros up to size bytes
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// signatureHash returns the hash the input at inIdx signs: the hash of
+// the trimmed copy of the transaction in which that input holds the
+// locking script of the output it spends, followed by the value of that
+// output. A signer told a wrong value makes a signature that doesn't verify
+func (tx *Transaction) signatureHash(inIdx int, spent TXOutput) []byte {
 	txCopy := tx.TrimmedCopy()
+	txCopy.ID = []byte{}
+	txCopy.Inputs[inIdx].ScriptSig = spent.ScriptPubKey
 
-	for inID, in := range txCopy.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inID].Signature = nil
-		txCopy.Inputs[inID].PubKey = prevTX.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inID].PubKey = nil
+	var e encoder
+	e.transaction(&txCopy)
+	e.int64(int64(spent.Value))
+	hash := sha256.Sum256(e.buf.Bytes())
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privateKey, txCopy.ID)
-		if err != nil {
-			log.Panic(err)
+	return hash[:]
+}
+
+// checkPrevTXs makes sure that prevTXs holds every output spent by the
+// inputs of tx
+func checkPrevTXs(tx *Transaction, prevTXs map[string]Transaction) error {
+	for _, in := range tx.Inputs {
+		prevTX := prevTXs[hex.EncodeToString(in.ID)]
+		if prevTX.ID == nil || in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return ErrTxNotFound
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+	}
 
-		tx.Inputs[inID].Signature = signature
+	return nil
+}
+
+// addPrevOutput records out as the output at outIdx of the transaction
+// txID in prevTXs. Sign and Verify only read the outputs spent by the
+// inputs, so they are enough to stand in for the previous transactions
+func addPrevOutput(prevTXs map[string]Transaction, txID []byte, outIdx int, out TXOutput) {
+	id := hex.EncodeToString(txID)
+	prevTX := prevTXs[id]
+	prevTX.ID = txID
+	for len(prevTX.Outputs) <= outIdx {
+		prevTX.Outputs = append(prevTX.Outputs, TXOutput{})
 	}
+	prevTX.Outputs[outIdx] = out
+	prevTXs[id] = prevTX
 }
 
-// Verify is used to verify transaction
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+// Verify is used to verify transaction, prevTXs must hold the
+// transactions spent by its inputs or ErrTxNotFound is returned. It runs
+// the unlocking script of every input against the locking script of the
+// output it spends and returns ErrInvalidSignature when one fails
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
-		}
+	if err := checkPrevTXs(tx, prevTXs); err != nil {
+		return err
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
 	for inID, in := range tx.Inputs {
 		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inID].Signature = nil
-		txCopy.Inputs[inID].PubKey = prevTX.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inID].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{Curve: curve,
-			X: &x,
-			Y: &y,
-		}
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
-			return false
+		if err := tx.verifyInput(inID, prevTX.Outputs[in.Out]); err != nil {
+			return err
 		}
 	}
-	return true
+	return nil
+}
+
+// verifyInput runs the unlocking script of the input at inIdx against the
+// locking script of spent, the output it spends
+func (tx *Transaction) verifyInput(inIdx int, spent TXOutput) error {
+	checkSig := func(signature, pubKey []byte) bool {
+		return verifySignature(tx.signatureHash(inIdx, spent), signature, pubKey)
+	}
+	if err := verifyScript(tx.Inputs[inIdx].ScriptSig, spent.ScriptPubKey, checkSig); err != nil {
+		return ErrInvalidSignature
+	}
+
+	return nil
 }
 
-// TrimmedCopy creates a trimmed copy of Transaction to be used in signing
+// TrimmedCopy creates a copy of Transaction without unlocking scripts to
+// be used in signing
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TXInput{in.ID, in.Out, nil, nil})
+		inputs = append(inputs, TXInput{in.ID, in.Out, nil})
 	}
 
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TXOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TXOutput{out.Value, out.ScriptPubKey})
 	}
 
 	txCopy := Transaction{tx.ID, outputs, inputs}
@@ -226,14 +348,17 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("       Data:      %x", []byte(input.ScriptSig)))
+		} else {
+			lines = append(lines, fmt.Sprintf("       ScriptSig: %s", input.ScriptSig))
+		}
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", output.ScriptPubKey))
 	}
 
 	return strings.Join(lines, "\n")
@@ -5,17 +5,22 @@ import (
 	"golang-blockchain/wallet"
 )
 
-// TXInput represents a transaction input
+// TXInput represents a transaction input, ScriptSig unlocks the output it
+// spends. The ScriptSig of a coinbase holds arbitrary data instead
 type TXInput struct {
 	ID        []byte
 	Out       int
-	Signature []byte
-	PubKey    []byte
+	ScriptSig Script
 }
 
-// UsesKey checks whether the address initiated the transaction
+// UsesKey checks whether the input unlocks a pay-to-pubkey-hash output
+// with the public key of the given hash
 func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := wallet.PublicKeyHash(in.PubKey)
+	ops, err := in.ScriptSig.parse()
+	if err != nil || len(ops) != 2 {
+		return false
+	}
+	lockingHash := wallet.PublicKeyHash(ops[1].data)
 
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
@@ -5,10 +5,11 @@ import (
 	"golang-blockchain/wallet"
 )
 
-// TXOutput represents a transaction output
+// TXOutput represents a transaction output, ScriptPubKey sets the
+// conditions to spend it
 type TXOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value        int
+	ScriptPubKey Script
 }
 
 // NewTXOutput creates a new TXOutput
@@ -18,14 +19,29 @@ func NewTXOutput(value int, address string) *TXOutput {
 	return txo
 }
 
-// Lock signs the output
+// Lock locks the output to the owner of the address with a
+// pay-to-pubkey-hash script
 func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := wallet.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	out.ScriptPubKey = P2PKHScript(addressPubKeyHash(string(address)))
+}
+
+// addressPubKeyHash returns the public key hash of a valid address
+func addressPubKeyHash(address string) []byte {
+	pubKeyHash := wallet.Base58Decode([]byte(address))
+
+	return pubKeyHash[1 : len(pubKeyHash)-4]
+}
+
+// PubKeyHash returns the public key hash the output pays to, or nil when
+// it isn't locked with a pay-to-pubkey-hash script
+func (out *TXOutput) PubKeyHash() []byte {
+	pubKeyHash, _ := out.ScriptPubKey.PubKeyHash()
+	return pubKeyHash
 }
 
 // IsLockedWithKey checks if the output can be used by the owner of the pubkey
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	lockingHash, ok := out.ScriptPubKey.PubKeyHash()
+
+	return ok && bytes.Compare(lockingHash, pubKeyHash) == 0
 }
@@ -0,0 +1,207 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	txIndexPrefix = []byte("tx-")
+	// txIndexKey marks a database whose transaction index is enabled
+	txIndexKey = []byte("txindex")
+)
+
+func txKey(txID []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID...)
+}
+
+// txLocation encodes the hash of a block and the position of a
+// transaction in it as the value of an index entry
+func txLocation(blockHash []byte, position int) []byte {
+	return append(append([]byte{}, blockHash...), IntToHex(int64(position))...)
+}
+
+// txIndexEnabled checks whether the transaction index is maintained, as
+// part of the caller's database transaction
+func txIndexEnabled(txn *badger.Txn) (bool, error) {
+	_, err := txn.Get(txIndexKey)
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// indexTransactions records the position of every transaction of a
+// connected block, as part of the caller's database transaction
+func indexTransactions(txn *badger.Txn, block *Block) error {
+	enabled, err := txIndexEnabled(txn)
+	if err != nil || !enabled {
+		return err
+	}
+
+	for i, tx := range block.Transactions {
+		if err := txn.Set(txKey(tx.ID), txLocation(block.Hash, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexTransactions drops the transactions of a disconnected block from
+// the index, as part of the caller's database transaction
+func unindexTransactions(txn *badger.Txn, block *Block) error {
+	enabled, err := txIndexEnabled(txn)
+	if err != nil || !enabled {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		if err := txn.Delete(txKey(tx.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TxIndexEnabled checks whether the chain keeps a transaction index
+func (bc *Blockchain) TxIndexEnabled() (bool, error) {
+	var enabled bool
+
+	err := bc.DB.View(func(txn *badger.Txn) error {
+		var err error
+		enabled, err = txIndexEnabled(txn)
+		return err
+	})
+
+	return enabled, err
+}
+
+// EnableTxIndex builds the transaction index from the main chain and
+// keeps it up to date from then on
+func (bc *Blockchain) EnableTxIndex() error {
+	db := bc.DB
+
+	if err := (UTXOSet{bc}).deleteByPrefix(txIndexPrefix); err != nil {
+		return err
+	}
+
+	txn := db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+	writes := 0
+
+	iter := bc.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		if writes >= utxoBatchSize {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = db.NewTransaction(true)
+			writes = 0
+		}
+
+		for i, tx := range block.Transactions {
+			if err := txn.Set(txKey(tx.ID), txLocation(block.Hash, i)); err != nil {
+				return err
+			}
+			writes++
+		}
+
+		if len(block.HashPrevBlock) == 0 {
+			break
+		}
+	}
+
+	// the marker is written last, together with the final batch, so an
+	// interrupted build leaves the index disabled
+	if err := txn.Set(txIndexKey, []byte{1}); err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// DisableTxIndex stops maintaining the transaction index and removes it
+func (bc *Blockchain) DisableTxIndex() error {
+	err := bc.DB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(txIndexKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	return UTXOSet{bc}.deleteByPrefix(txIndexPrefix)
+}
+
+// LocateTransaction returns a transaction of the main chain together with
+// the block confirming it, or ErrTxNotFound. It reads the transaction index when it is
+// enabled and scans the chain from the tip otherwise
+func (bc *Blockchain) LocateTransaction(ID []byte) (Transaction, Block, error) {
+	indexed, err := bc.TxIndexEnabled()
+	if err != nil {
+		return Transaction{}, Block{}, err
+	}
+	if indexed {
+		return bc.lookupTransaction(ID)
+	}
+
+	iter := bc.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return Transaction{}, Block{}, err
+		}
+
+		for _, tx := range block.Transactions {
+			if bytes.Compare(tx.ID, ID) == 0 {
+				return *tx, *block, nil
+			}
+		}
+
+		if len(block.HashPrevBlock) == 0 {
+			break
+		}
+	}
+
+	return Transaction{}, Block{}, ErrTxNotFound
+}
+
+// lookupTransaction finds a transaction through the transaction index
+func (bc *Blockchain) lookupTransaction(ID []byte) (Transaction, Block, error) {
+	var block *Block
+	var position int
+
+	err := bc.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(txKey(ID))
+		if err != nil {
+			return err
+		}
+		location, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		split := len(location) - 8
+		position = int(binary.BigEndian.Uint64(location[split:]))
+		block, err = getBlock(txn, location[:split])
+
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return Transaction{}, Block{}, ErrTxNotFound
+	}
+	if err != nil {
+		return Transaction{}, Block{}, err
+	}
+
+	return *block.Transactions[position], *block, nil
+}
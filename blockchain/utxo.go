@@ -0,0 +1,464 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	utxoPrefix = []byte("utxo-")
+	undoPrefix = []byte("undo-")
+)
+
+// utxoBatchSize limits the number of writes done in a single badger
+// transaction while rebuilding the UTXO set
+const utxoBatchSize = 100000
+
+// UTXOSet represents the set of unspent transaction outputs
+// stored in its own key prefix of the blockchain database
+type UTXOSet struct {
+	Blockchain *Blockchain
+}
+
+// UnspentOutput is an output of the UTXO set together with the height of
+// the block that created it and whether a coinbase created it
+type UnspentOutput struct {
+	TXOutput
+	Height   int
+	Coinbase bool
+}
+
+// SpendableAt reports whether the output can be spent by a transaction of
+// the block at the given height, coinbase outputs must be maturity blocks
+// deep
+func (out UnspentOutput) SpendableAt(height, maturity int) bool {
+	return !out.Coinbase || height-out.Height >= maturity
+}
+
+func (out UnspentOutput) serialize() []byte {
+	var e encoder
+	e.unspent(out)
+
+	return e.buf.Bytes()
+}
+
+func deserializeUnspentOutput(data []byte) (UnspentOutput, error) {
+	d := decoder{data: data}
+	out := d.unspent()
+
+	return out, d.finish()
+}
+
+// serializeUndo encodes the outputs spent by a block
+func serializeUndo(outs []UnspentOutput) []byte {
+	var e encoder
+	e.uint32(uint32(len(outs)))
+	for _, out := range outs {
+		e.unspent(out)
+	}
+
+	return e.buf.Bytes()
+}
+
+func deserializeUndo(data []byte) ([]UnspentOutput, error) {
+	var outs []UnspentOutput
+
+	d := decoder{data: data}
+	for n := d.count(24); n > 0; n-- {
+		outs = append(outs, d.unspent())
+	}
+
+	return outs, d.finish()
+}
+
+// utxoKey builds the database key of an output: prefix, transaction ID, output index
+func utxoKey(txID []byte, outIdx int) []byte {
+	return bytes.Join([][]byte{utxoPrefix, txID, IntToHex(int64(outIdx))}, []byte{})
+}
+
+// undoKey builds the database key of the outputs spent by a block
+func undoKey(blockHash []byte) []byte {
+	return append(append([]byte{}, undoPrefix...), blockHash...)
+}
+
+// parseUTXOKey splits a database key into transaction ID and output index
+func parseUTXOKey(key []byte) ([]byte, int) {
+	key = bytes.TrimPrefix(key, utxoPrefix)
+	outIdx := binary.BigEndian.Uint64(key[len(key)-8:])
+
+	return key[:len(key)-8], int(outIdx)
+}
+
+// FindSpendableOutputs finds and returns unspent outputs to reference in
+// inputs, skipping coinbase outputs that can't be spent in the next block
+// and outputs already spent by a transaction of the mempool
+func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int, error) {
+	unspentOuts := make(map[string][]int)
+	accumulated := 0
+	height, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, nil, err
+	}
+	height++
+	pending, err := Mempool{u.Blockchain}.spentOutputs()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = u.forEach(func(txID []byte, outIdx int, out UnspentOutput) bool {
+		_, spent := pending[outpoint(txID, outIdx)]
+		if out.IsLockedWithKey(pubKeyHash) && out.SpendableAt(height, u.Blockchain.CoinbaseMaturity) && !spent {
+			accumulated += out.Value
+			id := hex.EncodeToString(txID)
+			unspentOuts[id] = append(unspentOuts[id], outIdx)
+		}
+
+		return accumulated < amount
+	})
+
+	return accumulated, unspentOuts, err
+}
+
+// FindOutput returns an unspent output by its transaction ID and index, it
+// reports false when the output is not in the set
+func (u UTXOSet) FindOutput(txID []byte, outIdx int) (UnspentOutput, bool, error) {
+	var out UnspentOutput
+	var ok bool
+
+	err := u.Blockchain.DB.View(func(txn *badger.Txn) error {
+		var err error
+		out, ok, err = findOutput(txn, txID, outIdx)
+		return err
+	})
+
+	return out, ok, err
+}
+
+// Fee returns what a transaction pays to the miner, the value of the
+// outputs it spends minus the value of its own outputs. It reports false
+// when one of the spent outputs is not in the set, or when the values
+// don't add up to a fee within the maximum supply
+func (u UTXOSet) Fee(tx *Transaction) (int, bool, error) {
+	var spent []TXOutput
+
+	for _, in := range tx.Inputs {
+		out, ok, err := u.FindOutput(in.ID, in.Out)
+		if err != nil || !ok {
+			return 0, false, err
+		}
+		spent = append(spent, out.TXOutput)
+	}
+
+	fee, err := txFee(tx, spent)
+	if err != nil {
+		return 0, false, nil
+	}
+
+	return fee, true, nil
+}
+
+// matureAt reports whether every output spent by a transaction can be
+// spent in the block at the given height, outputs missing from the set
+// are left to the other checks
+func (u UTXOSet) matureAt(tx *Transaction, height int) (bool, error) {
+	for _, in := range tx.Inputs {
+		out, ok, err := u.FindOutput(in.ID, in.Out)
+		if err != nil {
+			return false, err
+		}
+		if ok && !out.SpendableAt(height, u.Blockchain.CoinbaseMaturity) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// findOutput looks an unspent output up as part of the caller's database
+// transaction
+func findOutput(txn *badger.Txn, txID []byte, outIdx int) (UnspentOutput, bool, error) {
+	item, err := txn.Get(utxoKey(txID, outIdx))
+	if err == badger.ErrKeyNotFound {
+		return UnspentOutput{}, false, nil
+	}
+	if err != nil {
+		return UnspentOutput{}, false, err
+	}
+
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return UnspentOutput{}, false, err
+	}
+	out, err := deserializeUnspentOutput(v)
+	if err != nil {
+		return UnspentOutput{}, false, err
+	}
+
+	return out, true, nil
+}
+
+// FindUTXO finds all unspent transaction outputs locked with the pubkey hash
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]TXOutput, error) {
+	var UTXOs []TXOutput
+
+	err := u.forEach(func(txID []byte, outIdx int, out UnspentOutput) bool {
+		if out.IsLockedWithKey(pubKeyHash) {
+			UTXOs = append(UTXOs, out.TXOutput)
+		}
+		return true
+	})
+
+	return UTXOs, err
+}
+
+// Balance returns the value of the outputs locked with the pubkey hash
+// that can be spent in the next block, and of the coinbase outputs that
+// are not mature yet
+func (u UTXOSet) Balance(pubKeyHash []byte) (int, int, error) {
+	spendable, immature := 0, 0
+	height, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, 0, err
+	}
+	height++
+
+	err = u.forEach(func(txID []byte, outIdx int, out UnspentOutput) bool {
+		if !out.IsLockedWithKey(pubKeyHash) {
+			return true
+		}
+		if out.SpendableAt(height, u.Blockchain.CoinbaseMaturity) {
+			spendable += out.Value
+		} else {
+			immature += out.Value
+		}
+		return true
+	})
+
+	return spendable, immature, err
+}
+
+// CountOutputs returns the number of outputs in the UTXO set
+func (u UTXOSet) CountOutputs() (int, error) {
+	counter := 0
+
+	err := u.forEach(func(txID []byte, outIdx int, out UnspentOutput) bool {
+		counter++
+		return true
+	})
+
+	return counter, err
+}
+
+// TotalValue returns the sum of the values of the outputs in the UTXO set,
+// the coins in circulation
+func (u UTXOSet) TotalValue() (int, error) {
+	total := 0
+
+	err := u.forEach(func(txID []byte, outIdx int, out UnspentOutput) bool {
+		total += out.Value
+		return true
+	})
+
+	return total, err
+}
+
+// forEach calls fn for every output in the set until fn returns false
+func (u UTXOSet) forEach(fn func(txID []byte, outIdx int, out UnspentOutput) bool) error {
+	db := u.Blockchain.DB
+
+	return db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			item := it.Item()
+			txID, outIdx := parseUTXOKey(item.KeyCopy(nil))
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			out, err := deserializeUnspentOutput(v)
+			if err != nil {
+				return err
+			}
+
+			if !fn(txID, outIdx, out) {
+				break
+			}
+		}
+
+		return nil
+	})
+}
+
+// Reindex rebuilds the UTXO set from scratch by scanning the whole chain
+func (u UTXOSet) Reindex() error {
+	db := u.Blockchain.DB
+
+	if err := u.deleteByPrefix(utxoPrefix); err != nil {
+		return err
+	}
+
+	UTXO, err := u.Blockchain.FindUTXO()
+	if err != nil {
+		return err
+	}
+
+	txn := db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+	writes := 0
+
+	for txID, outs := range UTXO {
+		key, err := hex.DecodeString(txID)
+		if err != nil {
+			return err
+		}
+
+		for outIdx, out := range outs {
+			if writes == utxoBatchSize {
+				if err := txn.Commit(); err != nil {
+					return err
+				}
+				txn = db.NewTransaction(true)
+				writes = 0
+			}
+
+			err = txn.Set(utxoKey(key, outIdx), out.serialize())
+			if err != nil {
+				return err
+			}
+			writes++
+		}
+	}
+
+	return txn.Commit()
+}
+
+// updateUTXO removes the outputs spent by the block from the UTXO set and
+// adds the ones it creates, as part of the caller's database transaction.
+// The spent outputs are kept as undo data of the block, so that it can be
+// disconnected again
+func updateUTXO(txn *badger.Txn, block *Block) error {
+	var undo []UnspentOutput
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, in := range tx.Inputs {
+				item, err := txn.Get(utxoKey(in.ID, in.Out))
+				if err != nil {
+					return err
+				}
+				v, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				out, err := deserializeUnspentOutput(v)
+				if err != nil {
+					return err
+				}
+				undo = append(undo, out)
+
+				if err := txn.Delete(utxoKey(in.ID, in.Out)); err != nil {
+					return err
+				}
+			}
+		}
+
+		for outIdx, out := range tx.Outputs {
+			unspent := UnspentOutput{out, block.Height, tx.IsCoinbase()}
+			if err := txn.Set(utxoKey(tx.ID, outIdx), unspent.serialize()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return txn.Set(undoKey(block.Hash), serializeUndo(undo))
+}
+
+// revertUTXO undoes updateUTXO for a block that is disconnected, as part
+// of the caller's database transaction
+func revertUTXO(txn *badger.Txn, block *Block) error {
+	item, err := txn.Get(undoKey(block.Hash))
+	if err != nil {
+		return err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	undo, err := deserializeUndo(v)
+	if err != nil {
+		return err
+	}
+
+	// walk backwards so that outputs created and spent within the block
+	// are restored before they are removed again
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+
+		for outIdx := range tx.Outputs {
+			if err := txn.Delete(utxoKey(tx.ID, outIdx)); err != nil {
+				return err
+			}
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for j := len(tx.Inputs) - 1; j >= 0; j-- {
+			in := tx.Inputs[j]
+			out := undo[len(undo)-1]
+			undo = undo[:len(undo)-1]
+
+			if err := txn.Set(utxoKey(in.ID, in.Out), out.serialize()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return txn.Delete(undoKey(block.Hash))
+}
+
+// deleteByPrefix removes every key starting with prefix
+func (u UTXOSet) deleteByPrefix(prefix []byte) error {
+	db := u.Blockchain.DB
+
+	deleteKeys := func(keys [][]byte) error {
+		return db.Update(func(txn *badger.Txn) error {
+			for _, key := range keys {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		var keys [][]byte
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+			if len(keys) == utxoBatchSize {
+				if err := deleteKeys(keys); err != nil {
+					return err
+				}
+				keys = nil
+			}
+		}
+
+		if len(keys) > 0 {
+			return deleteKeys(keys)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,422 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// maxFutureBlockTime is how many seconds a block's timestamp may be
+	// ahead of the local clock
+	maxFutureBlockTime = 2 * 60 * 60
+	// medianTimeSpan is the number of previous headers whose median
+	// timestamp a block's timestamp must exceed
+	medianTimeSpan = 11
+)
+
+// BlockValidationError describes why a block breaks a consensus rule
+type BlockValidationError struct {
+	Hash   []byte
+	Height int
+	Reason string
+}
+
+func (e *BlockValidationError) Error() string {
+	return fmt.Sprintf("Block %x at height %d is invalid: %s", e.Hash, e.Height, e.Reason)
+}
+
+func invalidBlock(block *Block, format string, a ...interface{}) error {
+	return &BlockValidationError{block.Hash, block.Height, fmt.Sprintf(format, a...)}
+}
+
+func invalidHeader(header *BlockHeader, format string, a ...interface{}) error {
+	return &BlockValidationError{header.BlockHash(), header.Height, fmt.Sprintf(format, a...)}
+}
+
+// outputLookup returns an unspent output of the chain a block builds on
+type outputLookup func(txID []byte, outIdx int) (UnspentOutput, bool, error)
+
+// ValidateBlock checks every consensus rule of a block that extends the
+// current tip of the chain
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	prev, err := bc.GetHeader(bc.LastHash)
+	if err != nil {
+		return err
+	}
+
+	bits, err := bc.NextBits(&prev)
+	if err != nil {
+		return err
+	}
+	medianTime, err := bc.MedianTimePast(&prev)
+	if err != nil {
+		return err
+	}
+
+	return validateBlock(block, &prev, bits, medianTime, bc.CoinbaseMaturity, UTXOSet{bc}.FindOutput)
+}
+
+// MedianTimePast returns the median timestamp of prev and the headers
+// before it, medianTimeSpan of them or fewer near the genesis block. The
+// block following prev must have a later timestamp. Only headers are read
+func (bc *Blockchain) MedianTimePast(prev *BlockHeader) (int64, error) {
+	times := []int64{prev.Time}
+
+	header := prev
+	for header.Height > 0 && len(times) < medianTimeSpan {
+		parent, err := bc.GetHeader(header.HashPrevBlock)
+		if err != nil {
+			return 0, err
+		}
+		header = &parent
+		times = append(times, header.Time)
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	return times[len(times)/2], nil
+}
+
+// validateBlock checks a block against the header of its parent, prev is
+// nil for the genesis block, the difficulty it must have, the median time
+// past of the parent, the coinbase maturity of the chain and the unspent
+// outputs of the chain up to the parent
+func validateBlock(block *Block, prev *BlockHeader, bits int, medianTime int64, maturity int, findOutput outputLookup) error {
+	if err := validateHeader(&block.BlockHeader, prev, bits, medianTime); err != nil {
+		return err
+	}
+	if err := validateMerkleRoot(block); err != nil {
+		return err
+	}
+
+	return validateTransactions(block, maturity, findOutput)
+}
+
+// validateHeader checks the proof of work of a header and its link to the
+// parent header, prev is nil for the genesis block, the difficulty it must
+// have and the median time past of the parent, which its timestamp must
+// exceed. It doesn't need the transactions of the block
+func validateHeader(header *BlockHeader, prev *BlockHeader, bits int, medianTime int64) error {
+	if header.Version != blockVersion {
+		return invalidHeader(header, "unknown version %d", header.Version)
+	}
+	if header.Bits != bits {
+		return invalidHeader(header, "difficulty is %d bits, expected %d", header.Bits, bits)
+	}
+
+	pow := NewProofOfWork(header)
+	if !pow.Validate() {
+		return invalidHeader(header, "proof of work is not valid")
+	}
+
+	if prev == nil {
+		if len(header.HashPrevBlock) != 0 || header.Height != 0 {
+			return invalidHeader(header, "genesis block must have no parent and height 0")
+		}
+	} else {
+		prevHash := prev.BlockHash()
+		if bytes.Compare(header.HashPrevBlock, prevHash) != 0 {
+			return invalidHeader(header, "previous block hash %x does not match %x", header.HashPrevBlock, prevHash)
+		}
+		if header.Height != prev.Height+1 {
+			return invalidHeader(header, "height must be %d", prev.Height+1)
+		}
+		if header.Time <= medianTime {
+			return invalidHeader(header, "timestamp is not after the median time past %d", medianTime)
+		}
+	}
+	if header.Time > time.Now().Unix()+maxFutureBlockTime {
+		return invalidHeader(header, "timestamp is too far in the future")
+	}
+
+	return nil
+}
+
+// validateMerkleRoot checks that the hash of a block is the hash of its
+// header and that the header commits to the transactions of the block
+func validateMerkleRoot(block *Block) error {
+	if bytes.Compare(block.Hash, block.BlockHash()) != 0 {
+		return invalidBlock(block, "hash does not match the block header")
+	}
+	if bytes.Compare(block.MerkleRoot, block.HashTransactions()) != 0 {
+		return invalidBlock(block, "Merkle root does not match the transactions")
+	}
+
+	return nil
+}
+
+// checkTransaction checks the rules a transaction must follow on its own,
+// whatever the chain. It returns a *TxValidationError when it breaks one
+func checkTransaction(tx *Transaction) error {
+	if bytes.Compare(tx.ID, tx.computeID()) != 0 {
+		return invalidTx(tx, "transaction has a wrong ID")
+	}
+	if len(tx.Outputs) == 0 {
+		return invalidTx(tx, "transaction has no outputs")
+	}
+	if len(tx.Inputs) == 0 {
+		return invalidTx(tx, "transaction has no inputs")
+	}
+
+	for outIdx, out := range tx.Outputs {
+		// once the supply is exhausted a coinbase without fees pays nothing
+		if out.Value < 0 || out.Value == 0 && !tx.IsCoinbase() {
+			return invalidTx(tx, "output %d has a non-positive value", outIdx)
+		}
+	}
+
+	spends := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		key := outpoint(in.ID, in.Out)
+		if spends[key] {
+			return invalidTx(tx, "transaction spends output %s twice", key)
+		}
+		spends[key] = true
+	}
+
+	return nil
+}
+
+// validateTransaction checks a transaction that is not a coinbase as part
+// of the block at the given height, against the unspent outputs of the
+// chain it builds on: the outputs it spends must exist and be maturity
+// blocks deep when they come from a coinbase, the fee can't be negative
+// and the scripts of its inputs must unlock them. It returns the fee, or a
+// *TxValidationError when a rule is broken
+func validateTransaction(tx *Transaction, height, maturity int, findOutput outputLookup) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, invalidTx(tx, "coinbase must be the first transaction of a block")
+	}
+	if err := checkTransaction(tx); err != nil {
+		return 0, err
+	}
+
+	var spent []TXOutput
+	prevTXs := make(map[string]Transaction)
+
+	for _, in := range tx.Inputs {
+		key := outpoint(in.ID, in.Out)
+		out, ok, err := findOutput(in.ID, in.Out)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, invalidTx(tx, "output %s is already spent or does not exist", key)
+		}
+		if !out.SpendableAt(height, maturity) {
+			return 0, invalidTx(tx, "coinbase output %s can't be spent before height %d", key, out.Height+maturity)
+		}
+		spent = append(spent, out.TXOutput)
+		addPrevOutput(prevTXs, in.ID, in.Out, out.TXOutput)
+	}
+
+	fee, err := txFee(tx, spent)
+	if err != nil {
+		return 0, invalidTx(tx, "%s", err)
+	}
+	if err := tx.Verify(prevTXs); err != nil {
+		return 0, invalidTx(tx, "%s", err)
+	}
+
+	return fee, nil
+}
+
+// validateTransactions checks the transactions of a block against the
+// coinbase maturity of the chain and its unspent outputs up to the parent
+func validateTransactions(block *Block, maturity int, findOutput outputLookup) error {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return invalidBlock(block, "first transaction must be a coinbase")
+	}
+
+	created := make(map[string]UnspentOutput)
+	spent := make(map[string]bool)
+	fees := 0
+
+	lookup := func(txID []byte, outIdx int) (UnspentOutput, bool, error) {
+		key := outpoint(txID, outIdx)
+		if spent[key] {
+			return UnspentOutput{}, false, nil
+		}
+		if out, ok := created[key]; ok {
+			return out, true, nil
+		}
+		return findOutput(txID, outIdx)
+	}
+
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return invalidBlock(block, "transaction %x is a second coinbase", tx.ID)
+		}
+
+		fee := 0
+		var err error
+		if tx.IsCoinbase() {
+			err = checkTransaction(tx)
+		} else {
+			fee, err = validateTransaction(tx, block.Height, maturity, lookup)
+		}
+		if txErr, ok := err.(*TxValidationError); ok {
+			return invalidBlock(block, "transaction %x: %s", tx.ID, txErr.Reason)
+		}
+		if err != nil {
+			return err
+		}
+
+		for outIdx := range tx.Outputs {
+			_, exists, err := lookup(tx.ID, outIdx)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return invalidBlock(block, "transaction %x already exists", tx.ID)
+			}
+		}
+
+		var ok bool
+		if fees, ok = addValue(fees, fee); !ok {
+			return invalidBlock(block, "fees of the block are more than the maximum supply")
+		}
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				spent[outpoint(in.ID, in.Out)] = true
+			}
+		}
+		for outIdx, out := range tx.Outputs {
+			created[outpoint(tx.ID, outIdx)] = UnspentOutput{out, block.Height, tx.IsCoinbase()}
+		}
+	}
+
+	coinbaseValue := 0
+	for _, out := range block.Transactions[0].Outputs {
+		var ok bool
+		if coinbaseValue, ok = addValue(coinbaseValue, out.Value); !ok {
+			return invalidBlock(block, "coinbase pays more than the maximum supply")
+		}
+	}
+	subsidy := BlockSubsidy(block.Height)
+	if coinbaseValue > subsidy+fees {
+		return invalidBlock(block, "coinbase pays %d, more than the subsidy of %d plus fees of %d", coinbaseValue, subsidy, fees)
+	}
+
+	return nil
+}
+
+// VerifyChain re-validates every stored block from the genesis block to
+// the tip and checks the UTXO set against the result. It returns a
+// *BlockValidationError for the first invalid block
+func (bc *Blockchain) VerifyChain() error {
+	UTXO := make(map[string]UnspentOutput)
+	lookup := func(txID []byte, outIdx int) (UnspentOutput, bool, error) {
+		out, ok := UTXO[outpoint(txID, outIdx)]
+		return out, ok, nil
+	}
+
+	hashes, err := bc.GetBlockHashes()
+	if err != nil {
+		return err
+	}
+	var prev *BlockHeader
+
+	for i := len(hashes) - 1; i >= 0; i-- {
+		block, err := bc.GetBlock(hashes[i])
+		if err != nil {
+			return err
+		}
+
+		bits := initialBits
+		var medianTime int64
+		if prev != nil {
+			bits, err = bc.NextBits(prev)
+			if err != nil {
+				return err
+			}
+			medianTime, err = bc.MedianTimePast(prev)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = validateBlock(&block, prev, bits, medianTime, bc.CoinbaseMaturity, lookup)
+		if err != nil {
+			return err
+		}
+
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() == false {
+				for _, in := range tx.Inputs {
+					delete(UTXO, outpoint(in.ID, in.Out))
+				}
+			}
+			for outIdx, out := range tx.Outputs {
+				UTXO[outpoint(tx.ID, outIdx)] = UnspentOutput{out, block.Height, tx.IsCoinbase()}
+			}
+		}
+
+		prev = &block.BlockHeader
+	}
+
+	stored := 0
+	mismatch := false
+	err = UTXOSet{bc}.forEach(func(txID []byte, outIdx int, out UnspentOutput) bool {
+		stored++
+		expected, ok := UTXO[outpoint(txID, outIdx)]
+		mismatch = !ok || expected.Value != out.Value || bytes.Compare(expected.ScriptPubKey, out.ScriptPubKey) != 0 ||
+			expected.Height != out.Height || expected.Coinbase != out.Coinbase
+		return !mismatch
+	})
+	if err != nil {
+		return err
+	}
+	if mismatch || stored != len(UTXO) {
+		return errors.New("UTXO set does not match the chain, run reindexutxo")
+	}
+
+	return nil
+}
+
+// VerifyHeaders re-validates the headers of the chain from the genesis
+// block to the tip, their proof of work, links and difficulty, without
+// reading any transaction. It returns a *BlockValidationError for the
+// first invalid header
+func (bc *Blockchain) VerifyHeaders() error {
+	hashes, err := bc.GetBlockHashes()
+	if err != nil {
+		return err
+	}
+	var prev *BlockHeader
+
+	for i := len(hashes) - 1; i >= 0; i-- {
+		header, err := bc.GetHeader(hashes[i])
+		if err != nil {
+			return err
+		}
+		if bytes.Compare(header.BlockHash(), hashes[i]) != 0 {
+			return &BlockValidationError{hashes[i], header.Height, "hash does not match the block header"}
+		}
+
+		bits := initialBits
+		var medianTime int64
+		if prev != nil {
+			bits, err = bc.NextBits(prev)
+			if err != nil {
+				return err
+			}
+			medianTime, err = bc.MedianTimePast(prev)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = validateHeader(&header, prev, bits, medianTime)
+		if err != nil {
+			return err
+		}
+
+		prev = &header
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package blockchain
+
+import (
+	"golang-blockchain/wallet"
+	"math"
+	"testing"
+)
+
+// outputsTo returns outputs paying the given values to w
+func outputsTo(w *wallet.Wallet, values ...int) []TXOutput {
+	var outs []TXOutput
+	for _, value := range values {
+		outs = append(outs, *NewTXOutput(value, string(w.Address())))
+	}
+
+	return outs
+}
+
+func TestTransactionsWithoutInputsAreRejected(t *testing.T) {
+	bc, w := newTestChain(t)
+	genesis := tipBlock(t, bc)
+
+	for _, values := range [][]int{
+		{5},
+		// sums to 0 when added up in an int
+		{math.MaxInt64, math.MaxInt64, 2},
+	} {
+		tx := &Transaction{Outputs: outputsTo(w, values...)}
+		tx.ID = tx.computeID()
+
+		_, err := mineOn(t, bc, w, genesis, 0, tx)
+		if _, ok := err.(*BlockValidationError); !ok {
+			t.Errorf("block with a transaction paying %v from no inputs was not rejected: %v", values, err)
+		}
+	}
+	if height, err := bc.GetBestHeight(); err != nil || height != 0 {
+		t.Errorf("height is %d (%v), expected 0", height, err)
+	}
+}
+
+func TestAddValue(t *testing.T) {
+	tests := []struct {
+		total, value int
+		want         int
+		ok           bool
+	}{
+		{0, 0, 0, true},
+		{10, 5, 15, true},
+		{0, MaxSupply, MaxSupply, true},
+		{1, MaxSupply, 1, false},
+		{MaxSupply, 1, MaxSupply, false},
+		{0, -1, 0, false},
+		{2, math.MaxInt64, 2, false},
+	}
+	for _, test := range tests {
+		if got, ok := addValue(test.total, test.value); got != test.want || ok != test.ok {
+			t.Errorf("addValue(%d, %d) = %d, %v, expected %d, %v", test.total, test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestOverflowingOutputsAreRejected(t *testing.T) {
+	bc, w, raw := newTestRawTx(t)
+
+	// spends the 100 coins of the genesis coinbase, the outputs add up to
+	// 0 in an int and would leave a fee of 100
+	raw.Tx.Outputs = outputsTo(w, math.MaxInt64, math.MaxInt64, 2)
+	raw.Tx.ID = raw.Tx.computeID()
+	if _, err := raw.Sign(w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	tx := &raw.Tx
+
+	if fee, err := raw.Fee(); err == nil {
+		t.Errorf("raw transaction pays a fee of %d", fee)
+	}
+	if fee, ok, err := (UTXOSet{bc}).Fee(tx); err != nil || ok {
+		t.Errorf("transaction pays a fee of %d (%v)", fee, err)
+	}
+	if err := (Mempool{bc}).Add(tx); err == nil {
+		t.Error("mempool accepted the transaction")
+	} else if _, ok := err.(*TxValidationError); !ok {
+		t.Error(err)
+	}
+
+	_, err := mineOn(t, bc, w, tipBlock(t, bc), raw.Spent[0].Value, tx)
+	if _, ok := err.(*BlockValidationError); !ok {
+		t.Errorf("block with the transaction was not rejected: %v", err)
+	}
+}
+
+func TestSignAndVerifyReadTheUTXOSet(t *testing.T) {
+	bc, w, raw := newTestRawTx(t)
+	tx := &raw.Tx
+
+	if err := bc.SignTransaction(tx, w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.VerifyTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	fee, err := raw.Fee()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mineOn(t, bc, w, tipBlock(t, bc), fee, tx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the outputs it spends are gone from the set once it is confirmed
+	if err := bc.VerifyTransaction(tx); err != ErrTxNotFound {
+		t.Errorf("verifying a confirmed transaction: got %v, expected ErrTxNotFound", err)
+	}
+	if err := bc.SignTransaction(tx, w.PrivateKey); err != ErrTxNotFound {
+		t.Errorf("signing a confirmed transaction: got %v, expected ErrTxNotFound", err)
+	}
+}
@@ -1,204 +1,1411 @@
 package cli
 
 import (
+	"context"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"golang-blockchain/blockchain"
+	"golang-blockchain/merkle"
+	"golang-blockchain/network"
 	"golang-blockchain/wallet"
 	"log"
 	"os"
-	"runtime"
+	"os/signal"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // CommandLine ...
 type CommandLine struct{}
 
+// Exit codes of the commands
+const (
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+	exitRejected = 4
+)
+
+// exitCode maps the errors of the blockchain and wallet packages to the
+// exit code of the command
+func exitCode(err error) int {
+	switch err {
+	case blockchain.ErrChainNotFound, blockchain.ErrBlockNotFound,
+		blockchain.ErrTxNotFound, wallet.ErrWalletNotFound:
+		return exitNotFound
+	case blockchain.ErrChainExists, blockchain.ErrInsufficientFunds,
+		blockchain.ErrInvalidAddress, blockchain.ErrInvalidSignature, blockchain.ErrTxIncomplete,
+		wallet.ErrWrongPassphrase, wallet.ErrWalletEncrypted, wallet.ErrWalletNotEncrypted,
+		wallet.ErrInvalidMnemonic, wallet.ErrWalletExists, wallet.ErrWatchOnly,
+		wallet.ErrAddressInWallet, wallet.ErrInvalidPublicKey, wallet.ErrInvalidPrivateKey:
+		return exitRejected
+	}
+
+	switch err.(type) {
+	case *blockchain.BlockValidationError, *blockchain.TxValidationError:
+		return exitRejected
+	}
+
+	return exitError
+}
+
+// stringList is a flag that may be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// intList is an integer flag that may be given several times
+type intList []int
+
+func (l *intList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *intList) Set(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, n)
+	return nil
+}
+
+// miningOptions returns the mining settings of the commands, progress is
+// reported on stderr
+func miningOptions(workers int) blockchain.MiningOptions {
+	return blockchain.MiningOptions{
+		Workers: workers,
+		Progress: func(hashes uint64, elapsed time.Duration) {
+			fmt.Fprintf(os.Stderr, "Mining: %d hashes in %s, %.0f hashes/s\n", hashes, elapsed.Round(time.Second), float64(hashes)/elapsed.Seconds())
+		},
+	}
+}
+
+// interruptContext returns a context that is cancelled by Ctrl-C, so that
+// mining stops and the database gets closed
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+
+	return ctx, cancel
+}
+
 func (cli *CommandLine) printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
+	fmt.Println("Usage: [-datadir DIR] [-conf FILE] [-workers N] [-maturity N] COMMAND")
+	fmt.Println(" -datadir DIR - Directory of the chain and the wallets, also BLOCKCHAIN_DATADIR env. var. or datadir in the config file")
+	fmt.Println("    defaults to ./tmp, or ./tmp/NODE_ID when NODE_ID env. var. or nodeid in the config file is set")
+	fmt.Println(" -conf FILE - Config file with \"datadir = DIR\", \"nodeid = ID\", \"workers = N\" and \"maturity = N\" lines, also BLOCKCHAIN_CONF env. var., defaults to ./blockchain.conf")
+	fmt.Println(" -workers N - Number of goroutines mining blocks, also workers in the config file, defaults to the number of CPUs")
+	fmt.Printf(" -maturity N - Number of blocks after which coinbase outputs can be spent, also maturity in the config file, defaults to %d\n", blockchain.DefaultCoinbaseMaturity)
+	fmt.Println("    it is stored in the chain by createblockchain, other commands refuse a value that doesn't match it")
+	fmt.Println("Commands:")
+	fmt.Println(" getbalance -address ADDRESS - get the balance for an address, split into spendable coins and immature coinbase rewards")
+	fmt.Println("    without -address, the balance of every address of the wallet, watch-only ones included and flagged")
+	fmt.Println(" history -address ADDRESS - Lists the transactions paying to or spending from an address, or every address of the wallet")
 	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
 	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT - Send amount of coins")
-	fmt.Println(" createwallet - Creates a new Wallet")
-	fmt.Println(" listaddresses - Lists the addresses in our wallet file")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT -fee FEE -change ADDRESS -newchange -dryrun -mine -relay - Send amount of coins")
+	fmt.Println("    -to and -amount may be repeated to pay several addresses in one transaction")
+	fmt.Println("    -fee FEE is left to the miner of the block, higher fees are mined first")
+	fmt.Println("    the change goes back to FROM, to -change ADDRESS or to a new wallet address with -newchange")
+	fmt.Println("    the transaction is added to the mempool, -mine mines it right away, -relay sends it to the network instead")
+	fmt.Println("    and -dryrun only prints it")
+	fmt.Println(" createrawtx -from FROM -to TO -amount AMOUNT -fee FEE -change ADDRESS -out FILE - Creates the unsigned transaction send would make")
+	fmt.Println("    FROM doesn't have to be in the wallet, the transaction and the outputs it spends are written to FILE or printed in hex")
+	fmt.Println(" signrawtx -in FILE -out FILE - Signs the inputs of a raw transaction the wallet holds the keys of, needs only the wallet file")
+	fmt.Println("    the signed transaction replaces the input file unless -out is given")
+	fmt.Println(" sendrawtx -in FILE -relay - Adds a fully signed raw transaction to the mempool, -relay sends it to the network instead")
+	fmt.Println(" mine -address ADDRESS - Mines the pending transactions into a new block and sends the reward to address")
+	fmt.Println(" createwallet - Derives a new address of the wallet, the first one makes the mnemonic phrase to write down")
+	fmt.Println(" restorewallet -gaplimit N - Restores the addresses of a mnemonic phrase into an empty wallet file, the phrase is asked for")
+	fmt.Println("    -mnemonic PHRASE gives it on the command line instead, where other users and the shell history can see it")
+	fmt.Println("    addresses are derived until N of them in a row were never used on the chain, 20 by default")
+	fmt.Println(" listaddresses - Lists the addresses in our wallet file, watch-only ones are flagged")
+	fmt.Println(" importaddress -address ADDRESS | -pubkey PUBKEY - Watches an address, or the address of a public key in hex, without its key")
+	fmt.Println("    its balance and history are followed, but send can't spend from it")
+	fmt.Println(" dumpprivkey -address ADDRESS - Prints the private key of an address of the wallet, Base58Check encoded like WIF")
+	fmt.Println(" importprivkey -key KEY -rescan - Adds a private key printed by dumpprivkey to the wallet, the key is asked when -key is not given")
+	fmt.Println("    the chain is scanned for the transactions and outputs of its address, -rescan=false skips it when there is no chain")
+	fmt.Println(" encryptwallet - Encrypts the wallet file with a new passphrase, the passphrase is then asked by the commands reading it")
+	fmt.Println(" changepassphrase - Changes the passphrase of the encrypted wallet file")
+	fmt.Println(" unlock -timeout SECONDS - Lets the commands read the encrypted wallet file without the passphrase for a while, 300 seconds by default")
+	fmt.Println("    the key is kept in the memory of a background process, never on disk")
+	fmt.Println(" lock - Locks the wallet file again before the unlock timeout")
+	fmt.Println(" reindexutxo - Rebuilds the UTXO set and the height and address indexes")
+	fmt.Println(" getblock -height HEIGHT | -hash HASH -header - Prints the block of the chain at a height or with a hash, -header prints only its header")
+	fmt.Println(" getblockcount - Prints the height of the tip of the chain")
+	fmt.Println(" supply - Prints the coins issued up to the tip of the chain, the maximum supply and the next block subsidy")
+	fmt.Println(" gettransaction -id TXID - Prints a transaction and the block confirming it")
+	fmt.Println(" txindex -enable | -disable - Builds or removes the transaction index used to find transactions by ID")
+	fmt.Println(" verifychain -headers - Re-validates every block of the chain and reports the first invalid one, -headers checks only the headers")
+	fmt.Println(" merkleproof -txid TXID -block HASH - Prints and verifies the Merkle proof of a transaction in a block")
+	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. or nodeid in the config file. -miner enables mining")
+	fmt.Println("Passphrases are read from the terminal, or one per line from stdin when it is not a terminal")
+	fmt.Println("Exit codes: 1 error, 2 bad usage, 3 chain, block, transaction or wallet not found, 4 rejected")
 }
 
-func (cli *CommandLine) validateArgs() {
-	if len(os.Args) < 2 {
+func (cli *CommandLine) validateArgs(args []string) {
+	if len(args) < 1 {
 		cli.printUsage()
-		runtime.Goexit()
+		os.Exit(exitUsage)
 	}
 }
 
-func (cli *CommandLine) listAddresses() {
-	wallets, _ := wallet.CreateWallets()
-	addresses := wallets.GetAllAddresses()
+func (cli *CommandLine) startNode(nodeID, dataDir, minerAddress string, mining blockchain.MiningOptions) error {
+	fmt.Printf("Starting Node %s\n", nodeID)
+
+	if len(minerAddress) > 0 {
+		if !wallet.ValidateAddress(minerAddress) {
+			return blockchain.ErrInvalidAddress
+		}
+		fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
+	}
+	return network.StartServer(nodeID, dataDir, minerAddress, mining)
+}
+
+func (cli *CommandLine) listAddresses(dataDir string) error {
+	addresses, watchOnly, err := walletAddresses(dataDir)
+	if err != nil {
+		return err
+	}
 
 	for _, address := range addresses {
-		fmt.Println(address)
+		fmt.Printf("%s%s\n", address, watchOnlyLabel(watchOnly[address]))
+	}
+	return nil
+}
+
+func (cli *CommandLine) importAddress(address, pubKey, dataDir string) error {
+	var key []byte
+	if pubKey != "" {
+		var err error
+		key, err = hex.DecodeString(pubKey)
+		if err != nil || !wallet.ValidatePublicKey(key) {
+			return wallet.ErrInvalidPublicKey
+		}
+		if address == "" {
+			address = wallet.AddressOf(key)
+		}
 	}
+	if !wallet.ValidateAddress(address) {
+		return blockchain.ErrInvalidAddress
+	}
+
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return err
+	}
+	if err := wallets.AddWatchOnly(address, key); err != nil {
+		return err
+	}
+	if err := wallets.SaveToFile(dataDir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Watching %s\n", address)
+	return nil
 }
 
-func (cli *CommandLine) createWallet() {
-	wallets, _ := wallet.CreateWallets()
-	address := wallets.AddWallet()
-	wallets.SaveToFile()
+func (cli *CommandLine) dumpPrivKey(address, dataDir string) error {
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return err
+	}
+	key, err := wallets.DumpPrivKey(address)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(key)
+	return nil
+}
+
+func (cli *CommandLine) importPrivKey(key string, rescan bool, dataDir string) error {
+	var bc *blockchain.Blockchain
+	if rescan {
+		var err error
+		bc, err = blockchain.ContinueBlockchain(dataDir)
+		if err != nil {
+			return err
+		}
+		defer bc.DB.Close()
+	}
+
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return err
+	}
+
+	if key == "" {
+		secret, err := readPassphrase("Private key: ")
+		if err != nil {
+			return err
+		}
+		key = string(secret)
+	}
+	address, err := wallets.ImportPrivKey(strings.TrimSpace(key))
+	if err != nil {
+		return err
+	}
+	if err := wallets.SaveToFile(dataDir); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %s\n", address)
+	fmt.Println("The key is not derived from the mnemonic phrase, keep a copy of it or of the wallet file")
+
+	if bc == nil {
+		return nil
+	}
+	pubKeyHash, err := pubKeyHash(address)
+	if err != nil {
+		return err
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: bc}
+	spendable, immature, err := UTXOSet.Balance(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	history, err := bc.History(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	UTXOs, err := UTXOSet.FindUTXO(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rescan found %d transactions and %d unspent outputs\n", len(history), len(UTXOs))
+	fmt.Printf("Balance of %s: %d\n", address, spendable+immature)
+	fmt.Printf("  spendable: %d\n", spendable)
+	fmt.Printf("  immature:  %d\n", immature)
+	return nil
+}
+
+func (cli *CommandLine) createWallet(dataDir string) error {
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return err
+	}
+	address, err := addAddress(wallets)
+	if err != nil {
+		return err
+	}
+	if err := wallets.SaveToFile(dataDir); err != nil {
+		return err
+	}
 
 	fmt.Printf("New address is: %s\n", address)
+	return nil
+}
+
+// addAddress derives a new address of the wallet. The mnemonic phrase is
+// printed when it is made for the first address, so that it gets written
+// down
+func addAddress(wallets *wallet.Wallets) (string, error) {
+	legacy := len(wallets.Wallets)
+	first := wallets.Mnemonic == ""
+	address, err := wallets.AddWallet()
+	if err != nil {
+		return "", err
+	}
+
+	if first {
+		fmt.Println("Write down the mnemonic phrase of the wallet, restorewallet derives its addresses from it:")
+		fmt.Printf("  %s\n", wallets.Mnemonic)
+		if legacy > 0 {
+			fmt.Printf("The %d addresses created before are not derived from it, keep a copy of the wallet file for them\n", legacy)
+		}
+	}
+
+	return address, nil
+}
+
+func (cli *CommandLine) restoreWallet(mnemonic string, gapLimit int, dataDir string) error {
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return err
+	}
+
+	if mnemonic == "" {
+		phrase, err := readPassphrase("Mnemonic phrase: ")
+		if err != nil {
+			return err
+		}
+		mnemonic = string(phrase)
+	} else {
+		fmt.Fprintln(os.Stderr, "Warning: a mnemonic phrase given with -mnemonic is visible to other users in the process list and stays in the shell history, leave it out to be asked for it")
+	}
+	mnemonic = strings.ToLower(strings.Join(strings.Fields(mnemonic), " "))
+
+	used := func(pubKeyHash []byte) (bool, error) {
+		history, err := bc.History(pubKeyHash)
+		return len(history) > 0, err
+	}
+	if err := wallets.Restore(mnemonic, gapLimit, used); err != nil {
+		return err
+	}
+	if err := wallets.SaveToFile(dataDir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %d addresses:\n", len(wallets.Wallets))
+	for _, address := range wallets.GetAllAddresses() {
+		fmt.Println(address)
+	}
+	return nil
+}
+
+func (cli *CommandLine) encryptWallet(dataDir string) error {
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return err
+	}
+	if wallets.IsEncrypted() {
+		return wallet.ErrWalletEncrypted
+	}
+
+	passphrase, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+	if err := wallets.Encrypt(passphrase); err != nil {
+		return err
+	}
+	if err := wallets.SaveToFile(dataDir); err != nil {
+		return err
+	}
+
+	fmt.Println("Wallet file encrypted, copies of the plaintext file made before are not")
+	return nil
+}
+
+func (cli *CommandLine) changePassphrase(dataDir string) error {
+	// the current passphrase is asked even when the wallet is unlocked
+	if err := wallet.Lock(dataDir); err != nil {
+		return err
+	}
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return err
+	}
+	if !wallets.IsEncrypted() {
+		return wallet.ErrWalletNotEncrypted
+	}
+
+	passphrase, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+	if err := wallets.ChangePassphrase(passphrase); err != nil {
+		return err
+	}
+	if err := wallets.SaveToFile(dataDir); err != nil {
+		return err
+	}
+
+	fmt.Println("Passphrase changed")
+	return nil
 }
 
-func (cli *CommandLine) printChain() {
-	bc := blockchain.ContinueBlockchain("")
+func (cli *CommandLine) printChain(dataDir string) error {
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
 	defer bc.DB.Close()
 	iter := bc.Iterator()
 
 	for {
-		block := iter.Next()
-
-		fmt.Printf("Prev. hash: %x\n", block.HashPrevBlock)
-		fmt.Printf("Hash: %x\n", block.Hash)
-		pow := blockchain.NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
+		block, err := iter.Next()
+		if err != nil {
+			return err
 		}
+
+		printBlock(block)
 		fmt.Println("--------------------------------------------------------")
 
 		if len(block.HashPrevBlock) == 0 {
 			break
 		}
 	}
+	return nil
 }
 
-func (cli *CommandLine) createBlockchain(address string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not valid")
+func printHeader(header *blockchain.BlockHeader) {
+	fmt.Printf("Prev. hash: %x\n", header.HashPrevBlock)
+	fmt.Printf("Hash: %x\n", header.BlockHash())
+	fmt.Printf("Height: %d\n", header.Height)
+	fmt.Printf("Version: %d\n", header.Version)
+	fmt.Printf("Merkle root: %x\n", header.MerkleRoot)
+	fmt.Printf("Time: %d\n", header.Time)
+	fmt.Printf("Bits: %d\n", header.Bits)
+	fmt.Printf("Nonce: %d\n", header.Nonce)
+	pow := blockchain.NewProofOfWork(header)
+	fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+}
+
+func printBlock(block *blockchain.Block) {
+	printHeader(&block.BlockHeader)
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+}
+
+func (cli *CommandLine) getBlock(height int, blockHash string, headerOnly bool, dataDir string) error {
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+
+	if blockHash != "" {
+		hash, err := hex.DecodeString(blockHash)
+		if err != nil {
+			return err
+		}
+		if headerOnly {
+			header, err := bc.GetHeader(hash)
+			if err != nil {
+				return err
+			}
+			printHeader(&header)
+			return nil
+		}
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		printBlock(&block)
+		return nil
+	}
+
+	if headerOnly {
+		header, err := bc.GetHeaderByHeight(height)
+		if err != nil {
+			return err
+		}
+		printHeader(&header)
+		return nil
+	}
+	block, err := bc.GetBlockByHeight(height)
+	if err != nil {
+		return err
+	}
+	printBlock(&block)
+	return nil
+}
+
+func (cli *CommandLine) getTransaction(txID, dataDir string) error {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		return err
+	}
+
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+
+	pending, err := blockchain.Mempool{Blockchain: bc}.Get(id)
+	if err == nil {
+		fmt.Println(pending)
+		fmt.Println("Pending in the mempool")
+		return nil
+	}
+	if err != blockchain.ErrTxNotFound {
+		return err
+	}
+
+	tx, block, err := bc.LocateTransaction(id)
+	if err != nil {
+		return err
+	}
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(tx)
+	fmt.Printf("Block: %x\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Confirmations: %d\n", bestHeight-block.Height+1)
+	return nil
+}
+
+func (cli *CommandLine) txIndex(enable, disable bool, dataDir string) error {
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+
+	if enable {
+		if err := bc.EnableTxIndex(); err != nil {
+			return err
+		}
+	}
+	if disable {
+		if err := bc.DisableTxIndex(); err != nil {
+			return err
+		}
+	}
+
+	enabled, err := bc.TxIndexEnabled()
+	if err != nil {
+		return err
+	}
+	if enabled {
+		fmt.Println("Transaction index is enabled")
+	} else {
+		fmt.Println("Transaction index is disabled")
+	}
+	return nil
+}
+
+func (cli *CommandLine) getBlockCount(dataDir string) error {
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	fmt.Println(height)
+	return nil
+}
+
+func (cli *CommandLine) supply(dataDir string) error {
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	unspent, err := blockchain.UTXOSet{Blockchain: bc}.TotalValue()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Height: %d\n", height)
+	fmt.Printf("Issued: %d of %d\n", blockchain.Supply(height), blockchain.MaxSupply)
+	fmt.Printf("Unspent: %d\n", unspent)
+	fmt.Printf("Next block subsidy: %d\n", blockchain.BlockSubsidy(height+1))
+	return nil
+}
+
+func (cli *CommandLine) reindexUTXO(dataDir string) error {
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+	UTXOSet := blockchain.UTXOSet{Blockchain: bc}
+	if err := UTXOSet.Reindex(); err != nil {
+		return err
+	}
+	if err := bc.ReindexHeights(); err != nil {
+		return err
+	}
+	if err := bc.ReindexAddresses(); err != nil {
+		return err
+	}
+
+	count, err := UTXOSet.CountOutputs()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Done! There are %d outputs in the UTXO set.\n", count)
+	return nil
+}
+
+func (cli *CommandLine) verifyChain(headersOnly bool, dataDir string) error {
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+
+	if headersOnly {
+		err = bc.VerifyHeaders()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Headers are valid")
+		return nil
+	}
+
+	err = bc.VerifyChain()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Chain is valid")
+	return nil
+}
+
+func (cli *CommandLine) merkleProof(txID, blockHash, dataDir string) error {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		return err
+	}
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		return err
+	}
+
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+
+	block, err := bc.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+	tx, proof, err := block.MerkleProof(id)
+	if err != nil {
+		return err
+	}
+
+	// the header commits to the root, so the proof is checked against it
+	root := block.MerkleRoot
+	fmt.Printf("Merkle root: %x\n", root)
+	fmt.Println(proof)
+	fmt.Printf("Verified: %s\n", strconv.FormatBool(merkle.VerifyProof(root, tx.Serialize(), proof)))
+	return nil
+}
+
+func (cli *CommandLine) createBlockchain(address, dataDir string, maturity int) error {
+	if maturity == -1 {
+		maturity = blockchain.DefaultCoinbaseMaturity
+	}
+	bc, err := blockchain.InitBlockchain(address, dataDir, maturity)
+	if err != nil {
+		return err
 	}
-	bc := blockchain.InitBlockchain(address)
 	defer bc.DB.Close()
 	fmt.Println("Finished")
+	return nil
 }
 
-func (cli *CommandLine) getBalance(address string) {
+// pubKeyHash decodes the public key hash of an address
+func pubKeyHash(address string) ([]byte, error) {
 	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not valid")
+		return nil, blockchain.ErrInvalidAddress
+	}
+	pubKeyHash := wallet.Base58Decode([]byte(address))
+
+	return pubKeyHash[1 : len(pubKeyHash)-4], nil
+}
+
+// walletAddresses returns the addresses of the wallet file, those with a
+// key first, and which ones are watch-only
+func walletAddresses(dataDir string) ([]string, map[string]bool, error) {
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addresses := wallets.GetAllAddresses()
+	watchOnly := make(map[string]bool)
+	for _, address := range wallets.GetWatchOnlyAddresses() {
+		addresses = append(addresses, address)
+		watchOnly[address] = true
+	}
+
+	return addresses, watchOnly, nil
+}
+
+// watchOnlyLabel flags the watch-only addresses in the output
+func watchOnlyLabel(watchOnly bool) string {
+	if watchOnly {
+		return " (watch-only)"
 	}
-	bc := blockchain.ContinueBlockchain(address)
+	return ""
+}
+
+func (cli *CommandLine) getBalance(address, dataDir string) error {
+	addresses := []string{address}
+	watchOnly := make(map[string]bool)
+	if address == "" {
+		var err error
+		addresses, watchOnly, err = walletAddresses(dataDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	var hashes [][]byte
+	for _, address := range addresses {
+		pubKeyHash, err := pubKeyHash(address)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, pubKeyHash)
+	}
+
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: bc}
 	defer bc.DB.Close()
 
-	balance := 0
-	pubKeyHash := wallet.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := bc.FindUTXO(pubKeyHash)
+	total, totalWatchOnly := 0, 0
+	for i, address := range addresses {
+		spendable, immature, err := UTXOSet.Balance(hashes[i])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Balance of %s%s: %d\n", address, watchOnlyLabel(watchOnly[address]), spendable+immature)
+		fmt.Printf("  spendable: %d\n", spendable)
+		fmt.Printf("  immature:  %d\n", immature)
+
+		if watchOnly[address] {
+			totalWatchOnly += spendable + immature
+		} else {
+			total += spendable + immature
+		}
+	}
+	if address == "" {
+		fmt.Printf("Total: %d\n", total)
+		fmt.Printf("Total watch-only: %d\n", totalWatchOnly)
+	}
+	return nil
+}
+
+func (cli *CommandLine) history(address, dataDir string) error {
+	addresses := []string{address}
+	watchOnly := make(map[string]bool)
+	if address == "" {
+		var err error
+		addresses, watchOnly, err = walletAddresses(dataDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	var hashes [][]byte
+	for _, address := range addresses {
+		pubKeyHash, err := pubKeyHash(address)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, pubKeyHash)
+	}
 
-	for _, out := range UTXOs {
-		balance += out.Value
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
 	}
-	fmt.Printf("Balance of %s: %d\n", address, balance)
+	defer bc.DB.Close()
+
+	for i, address := range addresses {
+		balance := 0
+		history, err := bc.History(hashes[i])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("History of %s%s:\n", address, watchOnlyLabel(watchOnly[address]))
+		for _, entry := range history {
+			balance += entry.Received - entry.Sent
+			fmt.Printf("Height %d, transaction %x\n", entry.Height, entry.TxID)
+			if entry.Received > 0 {
+				fmt.Printf("  incoming: %d\n", entry.Received)
+			}
+			if entry.Sent > 0 {
+				fmt.Printf("  outgoing: %d\n", entry.Sent)
+			}
+			fmt.Printf("  balance:  %d\n", balance)
+		}
+	}
+	return nil
+}
+
+// sendOptions holds the optional flags of the send command
+type sendOptions struct {
+	fee       int
+	change    string
+	newChange bool
+	dryRun    bool
+	mine      bool
+	relay     bool
+	mining    blockchain.MiningOptions
 }
 
-func (cli *CommandLine) send(from, to string, amount int) {
+func (cli *CommandLine) send(from string, payments []blockchain.Payment, dataDir string, opts sendOptions) error {
 	if !wallet.ValidateAddress(from) {
-		log.Panic("Address is not valid")
+		return blockchain.ErrInvalidAddress
 	}
-	if !wallet.ValidateAddress(to) {
-		log.Panic("Address is not valid")
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
 	}
-	bc := blockchain.ContinueBlockchain(from)
+	UTXOSet := blockchain.UTXOSet{Blockchain: bc}
 	defer bc.DB.Close()
 
-	tx := blockchain.NewTransaction(from, to, amount, bc)
-	bc.AddBlock([]*blockchain.Transaction{tx})
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return err
+	}
+	w, err := wallets.GetWallet(from)
+	if err != nil {
+		return err
+	}
+	if !wallets.IsEncrypted() {
+		fmt.Fprintln(os.Stderr, "Warning: the wallet file is not encrypted, run encryptwallet")
+	}
+
+	if opts.newChange {
+		if opts.dryRun {
+			opts.change, err = wallets.AddWallet()
+		} else {
+			opts.change, err = addAddress(wallets)
+		}
+		if err != nil {
+			return err
+		}
+		if !opts.dryRun {
+			if err := wallets.SaveToFile(dataDir); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Change address is: %s\n", opts.change)
+	}
+
+	tx, err := blockchain.NewTransaction(&w, payments, opts.fee, opts.change, &UTXOSet)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		fmt.Println(tx)
+		fmt.Printf("Fee: %d\n", opts.fee)
+		return nil
+	}
+	if opts.relay {
+		if err := network.SendTx(network.KnownNodes[0], tx); err != nil {
+			return err
+		}
+		fmt.Println("send tx")
+		return nil
+	}
+
+	mempool := blockchain.Mempool{Blockchain: bc}
+	err = mempool.Add(tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Transaction %x with a fee of %d added to the mempool\n", tx.ID, opts.fee)
+
+	if opts.mine {
+		ctx, cancel := interruptContext()
+		defer cancel()
+
+		block, err := mempool.Mine(ctx, from, opts.mining)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Mined block %x with %d transactions\n", block.Hash, len(block.Transactions))
+	}
 	fmt.Println("Success")
+	return nil
+}
+
+func (cli *CommandLine) mine(address, dataDir string, mining blockchain.MiningOptions) error {
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	mempool := blockchain.Mempool{Blockchain: bc}
+	block, err := mempool.Mine(ctx, address, mining)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Mined block %x with %d transactions\n", block.Hash, len(block.Transactions))
+	return nil
 }
 
 // Run is used to launch a cli
 func (cli *CommandLine) Run() {
-	cli.validateArgs()
+	cfg, args, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitUsage)
+	}
+	cli.validateArgs(args)
+	nodeID, dataDir := cfg.nodeID, cfg.dataDir
+	if args[0] != "createblockchain" {
+		if err := checkMaturity(cfg.maturity, dataDir); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitUsage)
+		}
+	}
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtx", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtx", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtx", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("importprivkey", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	changePassphraseCmd := flag.NewFlagSet("changepassphrase", flag.ExitOnError)
+	unlockCmd := flag.NewFlagSet("unlock", flag.ExitOnError)
+	lockCmd := flag.NewFlagSet("lock", flag.ExitOnError)
+	agentCmd := flag.NewFlagSet(agentCommand, flag.ExitOnError)
+	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
+	merkleProofCmd := flag.NewFlagSet("merkleproof", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	getBlockCountCmd := flag.NewFlagSet("getblockcount", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
+	getTransactionCmd := flag.NewFlagSet("gettransaction", flag.ExitOnError)
+	txIndexCmd := flag.NewFlagSet("txindex", flag.ExitOnError)
 
-	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for, every address of the wallet when empty")
+	historyAddress := historyCmd.String("address", "", "The address to list the transactions of, every address of the wallet when empty")
+	importAddressAddress := importAddressCmd.String("address", "", "The address to watch")
+	importAddressPubKey := importAddressCmd.String("pubkey", "", "The public key in hex of the address to watch")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("address", "", "The address to print the private key of")
+	importPrivKeyKey := importPrivKeyCmd.String("key", "", "The encoded private key, asked when not given")
+	importPrivKeyRescan := importPrivKeyCmd.Bool("rescan", true, "Scan the chain for the transactions and outputs of the key")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
-	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	var sendTo stringList
+	var sendAmount intList
+	sendCmd.Var(&sendTo, "to", "Destination wallet address, may be repeated")
+	sendCmd.Var(&sendAmount, "amount", "Amount to send, may be repeated")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to the miner")
+	sendChange := sendCmd.String("change", "", "Address receiving the change, defaults to the source address")
+	sendNewChange := sendCmd.Bool("newchange", false, "Send the change to a new address of the wallet")
+	sendDryRun := sendCmd.Bool("dryrun", false, "Print the transaction without adding it to the mempool")
+	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendRelay := sendCmd.Bool("relay", false, "Send the transaction to the network instead of the local mempool")
+	createRawTxFrom := createRawTxCmd.String("from", "", "Source address, its key may be on another machine")
+	var createRawTxTo stringList
+	var createRawTxAmount intList
+	createRawTxCmd.Var(&createRawTxTo, "to", "Destination wallet address, may be repeated")
+	createRawTxCmd.Var(&createRawTxAmount, "amount", "Amount to send, may be repeated")
+	createRawTxFee := createRawTxCmd.Int("fee", 0, "Fee paid to the miner")
+	createRawTxChange := createRawTxCmd.String("change", "", "Address receiving the change, defaults to the source address")
+	createRawTxOut := createRawTxCmd.String("out", "", "File to write the unsigned transaction to, printed in hex when empty")
+	signRawTxIn := signRawTxCmd.String("in", "", "File of the raw transaction to sign")
+	signRawTxOut := signRawTxCmd.String("out", "", "File to write the signed transaction to, defaults to the input file")
+	sendRawTxIn := sendRawTxCmd.String("in", "", "File of the signed raw transaction")
+	sendRawTxRelay := sendRawTxCmd.Bool("relay", false, "Send the transaction to the network instead of the local mempool")
+	mineAddress := mineCmd.String("address", "", "The address to send the block reward to")
+	merkleProofTxID := merkleProofCmd.String("txid", "", "The ID of the transaction to prove")
+	merkleProofBlock := merkleProofCmd.String("block", "", "The hash of the block containing the transaction")
+	getBlockHeight := getBlockCmd.Int("height", -1, "The height of the block in the chain")
+	getBlockHash := getBlockCmd.String("hash", "", "The hash of the block")
+	getBlockHeader := getBlockCmd.Bool("header", false, "Print only the header of the block")
+	verifyChainHeaders := verifyChainCmd.Bool("headers", false, "Check only the headers: proof of work, links and difficulty")
+	getTransactionID := getTransactionCmd.String("id", "", "The ID of the transaction")
+	txIndexEnable := txIndexCmd.Bool("enable", false, "Build the transaction index and maintain it")
+	txIndexDisable := txIndexCmd.Bool("disable", false, "Remove the transaction index")
+	restoreMnemonic := restoreWalletCmd.String("mnemonic", "", "The mnemonic phrase of the wallet, visible to other users, asked when not given")
+	restoreGapLimit := restoreWalletCmd.Int("gaplimit", wallet.DefaultGapLimit, "Number of unused addresses in a row that ends the scan")
+	unlockTimeout := unlockCmd.Int("timeout", 300, "Number of seconds the wallet stays unlocked")
+	agentTimeout := agentCmd.Int("timeout", 0, "Number of seconds the key is kept")
+	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "history":
+		err := historyCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
+		err := listAddressesCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importaddress":
+		err := importAddressCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumpprivkey":
+		err := dumpPrivKeyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importprivkey":
+		err := importPrivKeyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "encryptwallet":
+		err := encryptWalletCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "changepassphrase":
+		err := changePassphraseCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "unlock":
+		err := unlockCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "lock":
+		err := lockCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case agentCommand:
+		err := agentCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createrawtx":
+		err := createRawTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signrawtx":
+		err := signRawTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendrawtx":
+		err := sendRawTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindexutxo":
+		err := reindexUTXOCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "startnode":
+		err := startNodeCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "mine":
+		err := mineCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "merkleproof":
+		err := merkleProofCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblock":
+		err := getBlockCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblockcount":
+		err := getBlockCountCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "supply":
+		err := supplyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettransaction":
+		err := getTransactionCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "txindex":
+		err := txIndexCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	default:
 		cli.printUsage()
-		runtime.Goexit()
+		os.Exit(exitUsage)
 	}
 
 	if getBalanceCmd.Parsed() {
-		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			runtime.Goexit()
-		}
-		cli.getBalance(*getBalanceAddress)
+		err = cli.getBalance(*getBalanceAddress, dataDir)
+	}
+
+	if historyCmd.Parsed() {
+		err = cli.history(*historyAddress, dataDir)
 	}
 
 	if createBlockchainCmd.Parsed() {
 		if *createBlockchainAddress == "" {
 			createBlockchainCmd.Usage()
-			runtime.Goexit()
+			os.Exit(exitUsage)
 		}
-		cli.createBlockchain(*createBlockchainAddress)
+		err = cli.createBlockchain(*createBlockchainAddress, dataDir, cfg.maturity)
 	}
 
 	if printChainCmd.Parsed() {
-		cli.printChain()
+		err = cli.printChain(dataDir)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet()
+		err = cli.createWallet(dataDir)
 	}
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses()
+		err = cli.listAddresses(dataDir)
+	}
+	if importAddressCmd.Parsed() {
+		if *importAddressAddress == "" && *importAddressPubKey == "" {
+			importAddressCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.importAddress(*importAddressAddress, *importAddressPubKey, dataDir)
+	}
+	if restoreWalletCmd.Parsed() {
+		if *restoreGapLimit <= 0 {
+			restoreWalletCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.restoreWallet(*restoreMnemonic, *restoreGapLimit, dataDir)
+	}
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.dumpPrivKey(*dumpPrivKeyAddress, dataDir)
+	}
+	if importPrivKeyCmd.Parsed() {
+		err = cli.importPrivKey(*importPrivKeyKey, *importPrivKeyRescan, dataDir)
+	}
+	if encryptWalletCmd.Parsed() {
+		err = cli.encryptWallet(dataDir)
+	}
+	if changePassphraseCmd.Parsed() {
+		err = cli.changePassphrase(dataDir)
+	}
+	if unlockCmd.Parsed() {
+		if *unlockTimeout <= 0 {
+			unlockCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.unlockWallet(time.Duration(*unlockTimeout)*time.Second, dataDir)
+	}
+	if lockCmd.Parsed() {
+		err = cli.lockWallet(dataDir)
+	}
+	if agentCmd.Parsed() {
+		if *agentTimeout <= 0 {
+			agentCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.runAgent(time.Duration(*agentTimeout)*time.Second, dataDir)
+	}
+	if reindexUTXOCmd.Parsed() {
+		err = cli.reindexUTXO(dataDir)
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || len(sendTo) == 0 || len(sendTo) != len(sendAmount) || *sendFee < 0 {
 			sendCmd.Usage()
-			runtime.Goexit()
+			os.Exit(exitUsage)
+		}
+
+		var payments []blockchain.Payment
+		for i, to := range sendTo {
+			if sendAmount[i] <= 0 {
+				sendCmd.Usage()
+				os.Exit(exitUsage)
+			}
+			payments = append(payments, blockchain.Payment{Address: to, Amount: sendAmount[i]})
+		}
+
+		err = cli.send(*sendFrom, payments, dataDir, sendOptions{
+			fee:       *sendFee,
+			change:    *sendChange,
+			newChange: *sendNewChange,
+			dryRun:    *sendDryRun,
+			mine:      *sendMine,
+			relay:     *sendRelay,
+			mining:    miningOptions(cfg.workers),
+		})
+	}
+
+	if createRawTxCmd.Parsed() {
+		if *createRawTxFrom == "" || len(createRawTxTo) == 0 || len(createRawTxTo) != len(createRawTxAmount) || *createRawTxFee < 0 {
+			createRawTxCmd.Usage()
+			os.Exit(exitUsage)
+		}
+
+		var payments []blockchain.Payment
+		for i, to := range createRawTxTo {
+			if createRawTxAmount[i] <= 0 {
+				createRawTxCmd.Usage()
+				os.Exit(exitUsage)
+			}
+			payments = append(payments, blockchain.Payment{Address: to, Amount: createRawTxAmount[i]})
+		}
+
+		err = cli.createRawTx(*createRawTxFrom, payments, *createRawTxFee, *createRawTxChange, *createRawTxOut, dataDir)
+	}
+
+	if signRawTxCmd.Parsed() {
+		if *signRawTxIn == "" {
+			signRawTxCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.signRawTx(*signRawTxIn, *signRawTxOut, dataDir)
+	}
+
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxIn == "" {
+			sendRawTxCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.sendRawTx(*sendRawTxIn, *sendRawTxRelay, dataDir)
+	}
+
+	if mineCmd.Parsed() {
+		if *mineAddress == "" {
+			mineCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.mine(*mineAddress, dataDir, miningOptions(cfg.workers))
+	}
+
+	if merkleProofCmd.Parsed() {
+		if *merkleProofTxID == "" || *merkleProofBlock == "" {
+			merkleProofCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.merkleProof(*merkleProofTxID, *merkleProofBlock, dataDir)
+	}
+
+	if verifyChainCmd.Parsed() {
+		err = cli.verifyChain(*verifyChainHeaders, dataDir)
+	}
+
+	if getBlockCmd.Parsed() {
+		if (*getBlockHeight < 0) == (*getBlockHash == "") {
+			getBlockCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.getBlock(*getBlockHeight, *getBlockHash, *getBlockHeader, dataDir)
+	}
+
+	if getBlockCountCmd.Parsed() {
+		err = cli.getBlockCount(dataDir)
+	}
+
+	if supplyCmd.Parsed() {
+		err = cli.supply(dataDir)
+	}
+
+	if getTransactionCmd.Parsed() {
+		if *getTransactionID == "" {
+			getTransactionCmd.Usage()
+			os.Exit(exitUsage)
 		}
+		err = cli.getTransaction(*getTransactionID, dataDir)
+	}
+
+	if txIndexCmd.Parsed() {
+		if *txIndexEnable && *txIndexDisable {
+			txIndexCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		err = cli.txIndex(*txIndexEnable, *txIndexDisable, dataDir)
+	}
+
+	if startNodeCmd.Parsed() {
+		if nodeID == "" {
+			startNodeCmd.Usage()
+			fmt.Println("NODE_ID env. var. or nodeid in the config file is not set!")
+			os.Exit(exitUsage)
+		}
+		err = cli.startNode(nodeID, dataDir, *startNodeMiner, miningOptions(cfg.workers))
+	}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"golang-blockchain/blockchain"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultDataDir holds the chain and the wallets when no data directory
+	// is configured, nodes get a subdirectory named after their ID
+	defaultDataDir = "./tmp"
+	// defaultConfigFile is read from the working directory when it exists
+	defaultConfigFile = "blockchain.conf"
+)
+
+// config holds the settings shared by all commands
+type config struct {
+	dataDir string
+	nodeID  string
+	// workers is the number of goroutines mining blocks, 0 uses all CPUs
+	workers int
+	// maturity is the number of blocks after which coinbase outputs can
+	// be spent, -1 when it is not set. It is stored in the chain when it
+	// is created, and must match it afterwards
+	maturity int
+}
+
+// loadConfig parses the global flags in front of the command and returns
+// the settings together with the command and its arguments. A setting is
+// taken from the global flags first, then from the environment, then from
+// the config file
+func loadConfig(args []string) (config, []string, error) {
+	var cfg config
+
+	globalCmd := flag.NewFlagSet("global", flag.ExitOnError)
+	dataDir := globalCmd.String("datadir", "", "Directory holding the chain and the wallets, or BLOCKCHAIN_DATADIR env. var.")
+	confFile := globalCmd.String("conf", "", "Config file, or BLOCKCHAIN_CONF env. var., defaults to "+defaultConfigFile)
+	workers := globalCmd.Int("workers", 0, "Number of goroutines mining blocks, defaults to the number of CPUs")
+	maturity := globalCmd.Int("maturity", -1, fmt.Sprintf("Number of blocks after which coinbase outputs can be spent in a new chain, defaults to %d", blockchain.DefaultCoinbaseMaturity))
+	err := globalCmd.Parse(args)
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	path := firstOf(*confFile, os.Getenv("BLOCKCHAIN_CONF"))
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	cfg.nodeID = firstOf(os.Getenv("NODE_ID"), settings["nodeid"])
+	cfg.dataDir = firstOf(*dataDir, os.Getenv("BLOCKCHAIN_DATADIR"), settings["datadir"])
+	if cfg.dataDir == "" {
+		cfg.dataDir = filepath.Join(defaultDataDir, cfg.nodeID)
+	}
+
+	cfg.workers = *workers
+	if cfg.workers == 0 && settings["workers"] != "" {
+		cfg.workers, err = strconv.Atoi(settings["workers"])
+		if err != nil {
+			return cfg, nil, fmt.Errorf("workers in the config file: %s", err)
+		}
+	}
+	if cfg.workers < 0 {
+		return cfg, nil, fmt.Errorf("the number of workers can't be negative")
+	}
+
+	cfg.maturity = *maturity
+	if cfg.maturity < -1 {
+		return cfg, nil, fmt.Errorf("the coinbase maturity can't be negative")
+	}
+	if cfg.maturity == -1 && settings["maturity"] != "" {
+		cfg.maturity, err = strconv.Atoi(settings["maturity"])
+		if err != nil {
+			return cfg, nil, fmt.Errorf("maturity in the config file: %s", err)
+		}
+		if cfg.maturity < 0 {
+			return cfg, nil, fmt.Errorf("the coinbase maturity can't be negative")
+		}
+	}
+
+	return cfg, globalCmd.Args(), nil
+}
+
+// checkMaturity makes sure that a coinbase maturity set by the flags or
+// the config file is the one the chain in dataDir was created with, as it
+// can't change afterwards. Failures to open the chain are left to the
+// command
+func checkMaturity(maturity int, dataDir string) error {
+	if maturity == -1 {
+		return nil
+	}
+
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return nil
+	}
+	defer bc.DB.Close()
+
+	if bc.CoinbaseMaturity != maturity {
+		return fmt.Errorf("the chain was created with a coinbase maturity of %d, not %d", bc.CoinbaseMaturity, maturity)
+	}
+
+	return nil
+}
+
+// readConfigFile reads the "key = value" lines of a config file, lines
+// starting with # are comments. An empty path selects the default file,
+// which may be missing
+func readConfigFile(path string) (map[string]string, error) {
+	settings := make(map[string]string)
+
+	file, err := os.Open(firstOf(path, defaultConfigFile))
+	if path == "" && os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || (key != "datadir" && key != "nodeid" && key != "workers" && key != "maturity") {
+			return nil, fmt.Errorf("%s:%d: expected datadir = DIR, nodeid = ID, workers = N or maturity = N", file.Name(), line)
+		}
+		settings[key] = strings.TrimSpace(parts[1])
+	}
+
+	return settings, scanner.Err()
+}
+
+// firstOf returns the first non-empty value
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
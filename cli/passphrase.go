@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// stdin reads the passphrases piped to the commands, it is shared so that
+// several passphrases can be read from the same input
+var stdin = bufio.NewReader(os.Stdin)
+
+// readPassphrase prints the prompt on stderr and reads a passphrase from
+// the terminal without echoing it, or a line of stdin when it is not a
+// terminal
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return passphrase, err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, errors.New("no passphrase given")
+	}
+
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// walletPassphrase asks for the passphrase of an encrypted wallet file
+func walletPassphrase() ([]byte, error) {
+	return readPassphrase("Wallet passphrase: ")
+}
+
+// newPassphrase asks twice for a new passphrase
+func newPassphrase() ([]byte, error) {
+	passphrase, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("the passphrase can't be empty")
+	}
+
+	repeated, err := readPassphrase("Repeat the new passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if string(repeated) != string(passphrase) {
+		return nil, errors.New("the passphrases don't match")
+	}
+
+	return passphrase, nil
+}
@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"golang-blockchain/blockchain"
+	"golang-blockchain/network"
+	"golang-blockchain/wallet"
+	"io/ioutil"
+	"strings"
+)
+
+// Raw transaction files hold the hex of the canonical encoding of a
+// blockchain.RawTransaction on one line, so that they can be copied
+// between machines as text
+
+func readRawTx(path string) (*blockchain.RawTransaction, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, blockchain.ErrMalformedData
+	}
+
+	return blockchain.DeserializeRawTransaction(data)
+}
+
+func writeRawTx(path string, raw *blockchain.RawTransaction) error {
+	return ioutil.WriteFile(path, []byte(hex.EncodeToString(raw.Serialize())+"\n"), 0644)
+}
+
+// printRawTx prints a raw transaction with the values its inputs spend and
+// its fee, for the signer to check what is signed
+func printRawTx(raw *blockchain.RawTransaction) {
+	fmt.Println(raw.Tx)
+	for i, out := range raw.Spent {
+		fmt.Printf("Input %d spends %d locked by %s\n", i, out.Value, out.ScriptPubKey)
+	}
+	fee, err := raw.Fee()
+	if err != nil {
+		fmt.Printf("Fee: not valid, %s\n", err)
+		return
+	}
+	fmt.Printf("Fee: %d\n", fee)
+}
+
+// printSignatures tells how many inputs are left to sign
+func printSignatures(raw *blockchain.RawTransaction) {
+	unsigned := raw.Unsigned()
+	if len(unsigned) == 0 {
+		fmt.Println("The transaction is fully signed")
+		return
+	}
+	fmt.Printf("Inputs %v of %d still need signatures\n", unsigned, len(raw.Tx.Inputs))
+}
+
+func (cli *CommandLine) createRawTx(from string, payments []blockchain.Payment, fee int, change, out, dataDir string) error {
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: bc}
+	defer bc.DB.Close()
+
+	raw, err := blockchain.NewRawTransaction(from, payments, fee, change, &UTXOSet)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		fmt.Println(hex.EncodeToString(raw.Serialize()))
+		return nil
+	}
+	if err := writeRawTx(out, raw); err != nil {
+		return err
+	}
+	printRawTx(raw)
+	fmt.Printf("Unsigned transaction written to %s\n", out)
+	return nil
+}
+
+func (cli *CommandLine) signRawTx(in, out, dataDir string) error {
+	raw, err := readRawTx(in)
+	if err != nil {
+		return err
+	}
+	printRawTx(raw)
+
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return err
+	}
+
+	signed := 0
+	for _, address := range wallets.GetAllAddresses() {
+		w, err := wallets.GetWallet(address)
+		if err != nil {
+			return err
+		}
+		n, err := raw.Sign(w.PrivateKey)
+		if err != nil {
+			return err
+		}
+		signed += n
+	}
+
+	if out == "" {
+		out = in
+	}
+	if err := writeRawTx(out, raw); err != nil {
+		return err
+	}
+	fmt.Printf("Signed %d inputs, written to %s\n", signed, out)
+	printSignatures(raw)
+	return nil
+}
+
+func (cli *CommandLine) sendRawTx(in string, relay bool, dataDir string) error {
+	raw, err := readRawTx(in)
+	if err != nil {
+		return err
+	}
+	if len(raw.Unsigned()) > 0 {
+		printSignatures(raw)
+		return blockchain.ErrTxIncomplete
+	}
+	tx := &raw.Tx
+
+	if relay {
+		if err := network.SendTx(network.KnownNodes[0], tx); err != nil {
+			return err
+		}
+		fmt.Println("send tx")
+		return nil
+	}
+
+	bc, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.DB.Close()
+
+	mempool := blockchain.Mempool{Blockchain: bc}
+	if err := mempool.Add(tx); err != nil {
+		return err
+	}
+	fee, err := raw.Fee()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Transaction %x with a fee of %d added to the mempool\n", tx.ID, fee)
+	fmt.Println("Success")
+	return nil
+}
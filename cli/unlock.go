@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// agentCommand is the hidden command running the wallet agent started by
+// unlock
+const agentCommand = "walletagent"
+
+// unlockWallet starts an agent keeping the key of the encrypted wallet
+// file in memory for the given time. The agent is this program run again
+// in the background, it reads the key from a pipe
+func (cli *CommandLine) unlockWallet(timeout time.Duration, dataDir string) error {
+	if err := wallet.Lock(dataDir); err != nil {
+		return err
+	}
+	wallets, err := wallet.CreateWallets(dataDir, walletPassphrase)
+	if err != nil {
+		return err
+	}
+	key, err := wallets.AgentKey()
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	seconds := strconv.Itoa(int(timeout / time.Second))
+	agent := exec.Command(executable, "-datadir", dataDir, agentCommand, "-timeout", seconds)
+	stdin, err := agent.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := agent.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := agent.Start(); err != nil {
+		return err
+	}
+	_, err = stdin.Write(key)
+	stdin.Close()
+	if err != nil {
+		return err
+	}
+
+	// the agent prints a line once it listens
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		agent.Wait()
+		return errors.New("the wallet agent didn't start")
+	}
+	if err := agent.Process.Release(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wallet unlocked until %s\n", time.Now().Add(timeout).Format(time.Stamp))
+	return nil
+}
+
+func (cli *CommandLine) lockWallet(dataDir string) error {
+	if err := wallet.Lock(dataDir); err != nil {
+		return err
+	}
+
+	fmt.Println("Wallet locked")
+	return nil
+}
+
+// runAgent serves the key read from stdin until the timeout or lock
+func (cli *CommandLine) runAgent(timeout time.Duration, dataDir string) error {
+	key, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	// outlives the terminal unlock was run from
+	signal.Ignore(syscall.SIGHUP)
+
+	return wallet.ServeAgent(dataDir, key, timeout, func() {
+		fmt.Println("ready")
+		os.Stdout.Close()
+	})
+}
@@ -0,0 +1,126 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Tree represents a Merkle tree. Every level holds the hashes of the
+// level below it, the first level holds the hashes of the data and the
+// last one only the root. A level with an odd number of nodes pairs its
+// last node with itself.
+//
+// Leaves and inner nodes are hashed with a different prefix byte, so that
+// an inner node can't be passed off as a leaf in a proof
+type Tree struct {
+	levels [][][]byte
+}
+
+// Proof is a Merkle inclusion proof: the position of a leaf and the
+// sibling hashes on the path from that leaf to the root
+type Proof struct {
+	Index    int
+	Siblings [][]byte
+}
+
+const (
+	leafPrefix = byte(0x00)
+	nodePrefix = byte(0x01)
+)
+
+func hashLeaf(data []byte) []byte {
+	hash := sha256.Sum256(append([]byte{leafPrefix}, data...))
+	return hash[:]
+}
+
+func hashNodes(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{nodePrefix}, left...), right...))
+	return hash[:]
+}
+
+// NewTree builds a Merkle tree over data
+func NewTree(data [][]byte) *Tree {
+	var leaves [][]byte
+
+	for _, d := range data {
+		leaves = append(leaves, hashLeaf(d))
+	}
+	if len(leaves) == 0 {
+		leaves = append(leaves, hashLeaf([]byte{}))
+	}
+
+	levels := [][][]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		var next [][]byte
+
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, hashNodes(level[i], right))
+		}
+
+		levels = append(levels, next)
+		level = next
+	}
+
+	return &Tree{levels}
+}
+
+// Root returns the root hash of the tree
+func (t *Tree) Root() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Proof returns the inclusion proof of the leaf at index
+func (t *Tree) Proof(index int) (*Proof, error) {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil, errors.New("Leaf index is out of range")
+	}
+
+	proof := &Proof{Index: index}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		proof.Siblings = append(proof.Siblings, level[sibling])
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyProof checks that data is included in the tree with the given root
+func VerifyProof(root, data []byte, proof *Proof) bool {
+	hash := hashLeaf(data)
+	index := proof.Index
+
+	for _, sibling := range proof.Siblings {
+		if index%2 == 0 {
+			hash = hashNodes(hash, sibling)
+		} else {
+			hash = hashNodes(sibling, hash)
+		}
+		index /= 2
+	}
+
+	// an index with more levels than the proof doesn't match its leaf
+	return index == 0 && bytes.Compare(hash, root) == 0
+}
+
+// String is used to convert a proof into a string representation
+func (p Proof) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("--- Merkle proof for leaf %d:", p.Index))
+	for i, sibling := range p.Siblings {
+		lines = append(lines, fmt.Sprintf("     Level %d: %x", i, sibling))
+	}
+
+	return strings.Join(lines, "\n")
+}
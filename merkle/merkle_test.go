@@ -0,0 +1,113 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func testData(n int) [][]byte {
+	var data [][]byte
+	for i := 0; i < n; i++ {
+		data = append(data, []byte(fmt.Sprintf("tx %d", i)))
+	}
+	return data
+}
+
+func sum(parts ...[]byte) []byte {
+	hash := sha256.Sum256(bytes.Join(parts, nil))
+	return hash[:]
+}
+
+func TestRootIsDomainSeparated(t *testing.T) {
+	data := testData(3)
+	leaf := func(i int) []byte { return sum([]byte{0x00}, data[i]) }
+	node := func(left, right []byte) []byte { return sum([]byte{0x01}, left, right) }
+
+	// the last leaf of an odd level is paired with itself
+	expected := node(node(leaf(0), leaf(1)), node(leaf(2), leaf(2)))
+	if root := NewTree(data).Root(); bytes.Compare(root, expected) != 0 {
+		t.Errorf("root is %x, expected %x", root, expected)
+	}
+
+	if root := NewTree(nil).Root(); bytes.Compare(root, sum([]byte{0x00})) != 0 {
+		t.Errorf("root of an empty tree is %x", root)
+	}
+}
+
+func TestProofsOfEveryLeaf(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		data := testData(n)
+		tree := NewTree(data)
+
+		for i := range data {
+			proof, err := tree.Proof(i)
+			if err != nil {
+				t.Fatalf("%d leaves, leaf %d: %s", n, i, err)
+			}
+			if !VerifyProof(tree.Root(), data[i], proof) {
+				t.Errorf("%d leaves, leaf %d: proof does not verify", n, i)
+			}
+			if VerifyProof(tree.Root(), []byte("other"), proof) {
+				t.Errorf("%d leaves, leaf %d: proof verifies other data", n, i)
+			}
+		}
+
+		if _, err := tree.Proof(n); err == nil {
+			t.Errorf("%d leaves: proof of leaf %d out of range", n, n)
+		}
+		if _, err := tree.Proof(-1); err == nil {
+			t.Errorf("%d leaves: proof of leaf -1", n)
+		}
+	}
+}
+
+func TestTamperedProofs(t *testing.T) {
+	data := testData(5)
+	tree := NewTree(data)
+	root := tree.Root()
+
+	copyProof := func(p *Proof) *Proof {
+		c := &Proof{Index: p.Index}
+		for _, sibling := range p.Siblings {
+			c.Siblings = append(c.Siblings, append([]byte{}, sibling...))
+		}
+		return c
+	}
+
+	proof, err := tree.Proof(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := map[string]func(p *Proof){
+		"flipped sibling byte": func(p *Proof) { p.Siblings[1][0] ^= 1 },
+		"wrong index":          func(p *Proof) { p.Index = 0 },
+		"index past the tree":  func(p *Proof) { p.Index += 1 << uint(len(p.Siblings)) },
+		"missing sibling":      func(p *Proof) { p.Siblings = p.Siblings[:len(p.Siblings)-1] },
+		"extra sibling":        func(p *Proof) { p.Siblings = append(p.Siblings, root) },
+		"swapped siblings":     func(p *Proof) { p.Siblings[0], p.Siblings[1] = p.Siblings[1], p.Siblings[0] },
+	}
+	for name, tamper := range tampered {
+		p := copyProof(proof)
+		tamper(p)
+		if VerifyProof(root, data[1], p) {
+			t.Errorf("%s: tampered proof verifies", name)
+		}
+	}
+}
+
+func TestInnerNodeIsNotALeaf(t *testing.T) {
+	data := testData(4)
+	tree := NewTree(data)
+
+	// the concatenated children of an inner node hash to that node without
+	// the prefixes, and would then prove a leaf that is not in the tree
+	inner := append(append([]byte{}, tree.levels[0][0]...), tree.levels[0][1]...)
+	proof := &Proof{Index: 0, Siblings: [][]byte{tree.levels[1][1]}}
+
+	if VerifyProof(tree.Root(), inner, proof) {
+		t.Error("inner node is accepted as a leaf")
+	}
+}
@@ -0,0 +1,776 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang-blockchain/blockchain"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	protocol = "tcp"
+	// version is the version of the protocol, version 2 replaced the gob
+	// encoding of the messages with the canonical one
+	version       = 2
+	commandLength = 12
+
+	// maxRequestSize bounds the requests read from a peer, it leaves room
+	// for a full block and the inventory of a long chain
+	maxRequestSize = 32 << 20
+	// readTimeout bounds the time a peer may take to send its request
+	readTimeout = 30 * time.Second
+)
+
+var (
+	nodeAddress     string
+	minerAddress    string
+	KnownNodes      = []string{"localhost:3000"}
+	blocksInTransit = [][]byte{}
+
+	// chainLock serializes access to the chain between connection handlers
+	// and the miner
+	chainLock sync.Mutex
+
+	// newTxs wakes up the miner when a transaction enters the mempool
+	newTxs = make(chan struct{}, 1)
+
+	// miningOptions configures the proof of work search of the miner
+	miningOptions blockchain.MiningOptions
+	// cancelMining stops the block being mined, it is set while the miner
+	// runs and guarded by chainLock
+	cancelMining context.CancelFunc
+)
+
+// A request is a command padded to commandLength bytes followed by a
+// message in the canonical encoding of the blockchain package:
+//
+//	Addr        uint32 count | bytes address...
+//	Block       bytes AddrFrom | bytes Block
+//	GetBlocks   bytes AddrFrom
+//	GetData     bytes AddrFrom | bytes Type | bytes ID
+//	Inv         bytes AddrFrom | bytes Type | uint32 count | bytes item...
+//	Tx          bytes AddrFrom | bytes Transaction
+//	Version     int64 Version | int64 BestHeight | bytes AddrFrom
+//
+// where strings are encoded as bytes. A message must be read completely,
+// trailing bytes are rejected.
+
+// message is a payload of a request
+type message interface {
+	encode(e *blockchain.Encoder)
+	decode(d *blockchain.Decoder)
+}
+
+// Addr is used to share known node addresses
+type Addr struct {
+	AddrList []string
+}
+
+// Block carries a serialized block
+type Block struct {
+	AddrFrom string
+	Block    []byte
+}
+
+// GetBlocks asks a node for the hashes of all its blocks
+type GetBlocks struct {
+	AddrFrom string
+}
+
+// GetData asks a node for a single block or transaction
+type GetData struct {
+	AddrFrom string
+	Type     string
+	ID       []byte
+}
+
+// Inv announces blocks or transactions the sender has
+type Inv struct {
+	AddrFrom string
+	Type     string
+	Items    [][]byte
+}
+
+// Tx carries a serialized transaction
+type Tx struct {
+	AddrFrom    string
+	Transaction []byte
+}
+
+// Version is exchanged when nodes connect to compare their chains
+type Version struct {
+	Version    int
+	BestHeight int
+	AddrFrom   string
+}
+
+func (m *Addr) encode(e *blockchain.Encoder) {
+	e.Uint32(uint32(len(m.AddrList)))
+	for _, addr := range m.AddrList {
+		e.Bytes([]byte(addr))
+	}
+}
+
+func (m *Addr) decode(d *blockchain.Decoder) {
+	m.AddrList = make([]string, d.Count(4))
+	for i := range m.AddrList {
+		m.AddrList[i] = string(d.Bytes())
+	}
+}
+
+func (m *Block) encode(e *blockchain.Encoder) {
+	e.Bytes([]byte(m.AddrFrom))
+	e.Bytes(m.Block)
+}
+
+func (m *Block) decode(d *blockchain.Decoder) {
+	m.AddrFrom = string(d.Bytes())
+	m.Block = d.Bytes()
+}
+
+func (m *GetBlocks) encode(e *blockchain.Encoder) {
+	e.Bytes([]byte(m.AddrFrom))
+}
+
+func (m *GetBlocks) decode(d *blockchain.Decoder) {
+	m.AddrFrom = string(d.Bytes())
+}
+
+func (m *GetData) encode(e *blockchain.Encoder) {
+	e.Bytes([]byte(m.AddrFrom))
+	e.Bytes([]byte(m.Type))
+	e.Bytes(m.ID)
+}
+
+func (m *GetData) decode(d *blockchain.Decoder) {
+	m.AddrFrom = string(d.Bytes())
+	m.Type = string(d.Bytes())
+	m.ID = d.Bytes()
+}
+
+func (m *Inv) encode(e *blockchain.Encoder) {
+	e.Bytes([]byte(m.AddrFrom))
+	e.Bytes([]byte(m.Type))
+	e.Uint32(uint32(len(m.Items)))
+	for _, item := range m.Items {
+		e.Bytes(item)
+	}
+}
+
+func (m *Inv) decode(d *blockchain.Decoder) {
+	m.AddrFrom = string(d.Bytes())
+	m.Type = string(d.Bytes())
+	m.Items = make([][]byte, d.Count(4))
+	for i := range m.Items {
+		m.Items[i] = d.Bytes()
+	}
+}
+
+func (m *Tx) encode(e *blockchain.Encoder) {
+	e.Bytes([]byte(m.AddrFrom))
+	e.Bytes(m.Transaction)
+}
+
+func (m *Tx) decode(d *blockchain.Decoder) {
+	m.AddrFrom = string(d.Bytes())
+	m.Transaction = d.Bytes()
+}
+
+func (m *Version) encode(e *blockchain.Encoder) {
+	e.Int64(int64(m.Version))
+	e.Int64(int64(m.BestHeight))
+	e.Bytes([]byte(m.AddrFrom))
+}
+
+func (m *Version) decode(d *blockchain.Decoder) {
+	m.Version = int(d.Int64())
+	m.BestHeight = int(d.Int64())
+	m.AddrFrom = string(d.Bytes())
+}
+
+// CmdToBytes converts a command into a fixed length byte slice
+func CmdToBytes(cmd string) []byte {
+	var bytes [commandLength]byte
+
+	for i, c := range cmd {
+		bytes[i] = byte(c)
+	}
+
+	return bytes[:]
+}
+
+// BytesToCmd converts a fixed length byte slice back into a command
+func BytesToCmd(bytes []byte) string {
+	var cmd []byte
+
+	for _, b := range bytes {
+		if b != 0x0 {
+			cmd = append(cmd, b)
+		}
+	}
+
+	return fmt.Sprintf("%s", cmd)
+}
+
+// ExtractCmd returns the command part of a request
+func ExtractCmd(request []byte) []byte {
+	return request[:commandLength]
+}
+
+// RequestBlocks asks every known node for its blocks, the nodes that
+// can't be reached are reported and skipped
+func RequestBlocks() {
+	for _, node := range KnownNodes {
+		if err := SendGetBlocks(node); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// SendAddr sends the list of known nodes to addr
+func SendAddr(addr string) error {
+	nodes := Addr{append(KnownNodes, nodeAddress)}
+	payload := encodePayload(&nodes)
+	request := append(CmdToBytes("addr"), payload...)
+
+	return SendData(addr, request)
+}
+
+// SendBlock sends a block to addr
+func SendBlock(addr string, b *blockchain.Block) error {
+	data := Block{nodeAddress, b.Serialize()}
+	payload := encodePayload(&data)
+	request := append(CmdToBytes("block"), payload...)
+
+	return SendData(addr, request)
+}
+
+// SendInv announces blocks or transactions to addr
+func SendInv(addr, kind string, items [][]byte) error {
+	inventory := Inv{nodeAddress, kind, items}
+	payload := encodePayload(&inventory)
+	request := append(CmdToBytes("inv"), payload...)
+
+	return SendData(addr, request)
+}
+
+// SendTx sends a transaction to addr
+func SendTx(addr string, tnx *blockchain.Transaction) error {
+	data := Tx{nodeAddress, tnx.Serialize()}
+	payload := encodePayload(&data)
+	request := append(CmdToBytes("tx"), payload...)
+
+	return SendData(addr, request)
+}
+
+// SendVersion sends our version and chain height to addr
+func SendVersion(addr string, chain *blockchain.Blockchain) error {
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	payload := encodePayload(&Version{version, bestHeight, nodeAddress})
+
+	request := append(CmdToBytes("version"), payload...)
+
+	return SendData(addr, request)
+}
+
+// SendGetBlocks asks addr for the hashes of its blocks
+func SendGetBlocks(addr string) error {
+	payload := encodePayload(&GetBlocks{nodeAddress})
+	request := append(CmdToBytes("getblocks"), payload...)
+
+	return SendData(addr, request)
+}
+
+// SendGetData asks addr for a block or a transaction
+func SendGetData(addr, kind string, id []byte) error {
+	payload := encodePayload(&GetData{nodeAddress, kind, id})
+	request := append(CmdToBytes("getdata"), payload...)
+
+	return SendData(addr, request)
+}
+
+// SendData writes a request to addr, unreachable nodes are forgotten. It
+// returns an error when addr can't be reached or the request can't be
+// written
+func SendData(addr string, data []byte) error {
+	conn, err := net.Dial(protocol, addr)
+
+	if err != nil {
+		var updatedNodes []string
+
+		for _, node := range KnownNodes {
+			if node != addr {
+				updatedNodes = append(updatedNodes, node)
+			}
+		}
+
+		KnownNodes = updatedNodes
+
+		return fmt.Errorf("%s is not available: %s", addr, err)
+	}
+
+	defer conn.Close()
+
+	_, err = io.Copy(conn, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("sending to %s failed: %s", addr, err)
+	}
+
+	return nil
+}
+
+// HandleAddr adds the received addresses to the known nodes
+func HandleAddr(request []byte) error {
+	var payload Addr
+
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	for _, addr := range payload.AddrList {
+		if addr != nodeAddress && !NodeIsKnown(addr) {
+			KnownNodes = append(KnownNodes, addr)
+		}
+	}
+	fmt.Printf("there are %d known nodes\n", len(KnownNodes))
+	RequestBlocks()
+
+	return nil
+}
+
+// HandleBlock stores a received block and asks for the next one in transit
+func HandleBlock(request []byte, chain *blockchain.Blockchain) error {
+	var payload Block
+
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	block, err := blockchain.Deserialize(payload.Block)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Received a new block!")
+	added, err := chain.AddBlock(block)
+	if err != nil {
+		fmt.Printf("Rejected block: %s\n", err)
+	} else if added {
+		fmt.Printf("Added block %x\n", block.Hash)
+		if bytes.Compare(chain.LastHash, block.Hash) == 0 && cancelMining != nil {
+			// the block being mined no longer extends the tip
+			cancelMining()
+		}
+	} else if len(blocksInTransit) == 0 {
+		known, err := chain.HasBlock(block.HashPrevBlock)
+		if err != nil {
+			return err
+		}
+		if !known {
+			// we are missing the blocks between our tip and this one
+			return SendGetBlocks(payload.AddrFrom)
+		}
+	}
+
+	if len(blocksInTransit) > 0 {
+		blockHash := blocksInTransit[0]
+		blocksInTransit = blocksInTransit[1:]
+
+		return SendGetData(payload.AddrFrom, "block", blockHash)
+	}
+
+	return nil
+}
+
+// HandleInv requests announced blocks and transactions we don't have
+func HandleInv(request []byte, chain *blockchain.Blockchain) error {
+	var payload Inv
+
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
+
+	if payload.Type == "block" {
+		// items come from the tip backwards, download them oldest first
+		// so that every block extends our chain
+		blocksInTransit = [][]byte{}
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			known, err := chain.HasBlock(payload.Items[i])
+			if err != nil {
+				return err
+			}
+			if !known {
+				blocksInTransit = append(blocksInTransit, payload.Items[i])
+			}
+		}
+
+		if len(blocksInTransit) == 0 {
+			return nil
+		}
+
+		blockHash := blocksInTransit[0]
+		blocksInTransit = blocksInTransit[1:]
+
+		return SendGetData(payload.AddrFrom, "block", blockHash)
+	}
+
+	if payload.Type == "tx" && len(payload.Items) > 0 {
+		txID := payload.Items[0]
+
+		known, err := blockchain.Mempool{Blockchain: chain}.Has(txID)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return SendGetData(payload.AddrFrom, "tx", txID)
+		}
+	}
+
+	return nil
+}
+
+// HandleGetBlocks answers with the hashes of all our blocks
+func HandleGetBlocks(request []byte, chain *blockchain.Blockchain) error {
+	var payload GetBlocks
+
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	blocks, err := chain.GetBlockHashes()
+	if err != nil {
+		return err
+	}
+
+	return SendInv(payload.AddrFrom, "block", blocks)
+}
+
+// HandleGetData sends the requested block or transaction
+func HandleGetData(request []byte, chain *blockchain.Blockchain) error {
+	var payload GetData
+
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	if payload.Type == "block" {
+		block, err := chain.GetBlock(payload.ID)
+		if err != nil {
+			return nil
+		}
+
+		return SendBlock(payload.AddrFrom, &block)
+	}
+
+	if payload.Type == "tx" {
+		tx, err := blockchain.Mempool{Blockchain: chain}.Get(payload.ID)
+		if err == blockchain.ErrTxNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return SendTx(payload.AddrFrom, &tx)
+	}
+
+	return nil
+}
+
+// HandleTx puts a received transaction into the mempool, relays it and
+// wakes up the miner when this node is one
+func HandleTx(request []byte, chain *blockchain.Blockchain) error {
+	var payload Tx
+
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	tx, err := blockchain.DeserializeTransaction(payload.Transaction)
+	if err != nil {
+		return err
+	}
+	mempool := blockchain.Mempool{Blockchain: chain}
+
+	err = mempool.Add(&tx)
+	if err != nil {
+		fmt.Printf("Rejected transaction %x: %s\n", tx.ID, err)
+		return nil
+	}
+
+	count, err := mempool.Count()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s, %d\n", nodeAddress, count)
+
+	if len(KnownNodes) > 0 && nodeAddress == KnownNodes[0] {
+		for _, node := range KnownNodes {
+			if node != nodeAddress && node != payload.AddrFrom {
+				if err := SendInv(node, "tx", [][]byte{tx.ID}); err != nil {
+					fmt.Println(err)
+				}
+			}
+		}
+	}
+
+	if len(minerAddress) > 0 {
+		wakeMiner()
+	}
+
+	return nil
+}
+
+// wakeMiner makes the miner look at the mempool again
+func wakeMiner() {
+	select {
+	case newTxs <- struct{}{}:
+	default:
+	}
+}
+
+// MinerLoop mines the pending transactions of the mempool into new blocks
+// whenever new ones arrive and announces the blocks to the known nodes.
+// The chain is unlocked during the proof of work, a block received
+// meanwhile that becomes the new tip cancels it and the pending
+// transactions are mined again on top of it
+func MinerLoop(chain *blockchain.Blockchain) {
+	for range newTxs {
+		chainLock.Lock()
+
+		mempool := blockchain.Mempool{Blockchain: chain}
+		count, err := mempool.Count()
+		if err != nil {
+			fmt.Printf("Mining failed: %s\n", err)
+		}
+		if count == 0 {
+			chainLock.Unlock()
+			continue
+		}
+
+		newBlock, err := mempool.BlockTemplate(minerAddress)
+		if err != nil {
+			fmt.Printf("Mining failed: %s\n", err)
+			chainLock.Unlock()
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelMining = cancel
+		chainLock.Unlock()
+
+		err = newBlock.Mine(ctx, miningOptions)
+
+		chainLock.Lock()
+		cancelMining = nil
+		cancel()
+
+		if err == context.Canceled {
+			fmt.Println("Mining cancelled, the chain has a new tip")
+			chainLock.Unlock()
+			wakeMiner()
+			continue
+		}
+		if err == nil {
+			_, err = chain.AddBlock(newBlock)
+		}
+		if err != nil {
+			fmt.Printf("Mining failed: %s\n", err)
+			chainLock.Unlock()
+			continue
+		}
+		if bytes.Compare(chain.LastHash, newBlock.Hash) != 0 {
+			// another block extended the tip while the chain was unlocked
+			fmt.Println("Mined block is not on the main chain, mining again")
+			chainLock.Unlock()
+			wakeMiner()
+			continue
+		}
+		fmt.Printf("New Block mined with %d transactions\n", len(newBlock.Transactions))
+
+		for _, node := range KnownNodes {
+			if node != nodeAddress {
+				if err := SendInv(node, "block", [][]byte{newBlock.Hash}); err != nil {
+					fmt.Println(err)
+				}
+			}
+		}
+
+		chainLock.Unlock()
+	}
+}
+
+// HandleVersion compares chain heights and starts a sync when needed
+func HandleVersion(request []byte, chain *blockchain.Blockchain) error {
+	var payload Version
+
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	otherHeight := payload.BestHeight
+
+	if !NodeIsKnown(payload.AddrFrom) {
+		KnownNodes = append(KnownNodes, payload.AddrFrom)
+	}
+
+	if bestHeight < otherHeight {
+		return SendGetBlocks(payload.AddrFrom)
+	} else if bestHeight > otherHeight {
+		return SendVersion(payload.AddrFrom, chain)
+	}
+
+	return nil
+}
+
+// HandleConnection reads a request and dispatches it to its handler
+func HandleConnection(conn net.Conn, chain *blockchain.Blockchain) {
+	defer conn.Close()
+
+	// a peer can't hold the connection open or send more than a request
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	req, err := ioutil.ReadAll(io.LimitReader(conn, maxRequestSize+1))
+	if err != nil {
+		fmt.Printf("Reading from %s failed: %s\n", conn.RemoteAddr(), err)
+		return
+	}
+	if len(req) > maxRequestSize {
+		fmt.Printf("Dropped request of more than %d bytes from %s\n", maxRequestSize, conn.RemoteAddr())
+		return
+	}
+	if len(req) < commandLength {
+		return
+	}
+
+	command := BytesToCmd(req[:commandLength])
+	fmt.Printf("Received %s command\n", command)
+
+	chainLock.Lock()
+	defer chainLock.Unlock()
+
+	switch command {
+	case "addr":
+		err = HandleAddr(req)
+	case "block":
+		err = HandleBlock(req, chain)
+	case "inv":
+		err = HandleInv(req, chain)
+	case "getblocks":
+		err = HandleGetBlocks(req, chain)
+	case "getdata":
+		err = HandleGetData(req, chain)
+	case "tx":
+		err = HandleTx(req, chain)
+	case "version":
+		err = HandleVersion(req, chain)
+	default:
+		fmt.Println("Unknown command")
+	}
+	if err != nil {
+		// malformed messages are dropped, the peer can't stop the node
+		fmt.Printf("Dropped %s command: %s\n", command, err)
+	}
+}
+
+// StartServer starts a node listening on the port given by nodeID with the
+// chain in dataDir, blocks are mined with the given options and rewarded
+// to minerAddress when it is not empty. It only returns when the node
+// can't be started or stops accepting connections
+func StartServer(nodeID, dataDir, minerAddr string, mining blockchain.MiningOptions) error {
+	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
+	minerAddress = minerAddr
+	miningOptions = mining
+	ln, err := net.Listen(protocol, nodeAddress)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	chain, err := blockchain.ContinueBlockchain(dataDir)
+	if err != nil {
+		return err
+	}
+	go CloseDB(chain)
+
+	if len(minerAddress) > 0 {
+		go MinerLoop(chain)
+		// mine what was left in the mempool by a previous run
+		newTxs <- struct{}{}
+	}
+
+	if nodeAddress != KnownNodes[0] {
+		// the node still waits for peers when the seed node is down
+		if err := SendVersion(KnownNodes[0], chain); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go HandleConnection(conn, chain)
+	}
+}
+
+// encodePayload returns the canonical encoding of a message
+func encodePayload(payload message) []byte {
+	var e blockchain.Encoder
+	payload.encode(&e)
+
+	return e.Data()
+}
+
+// decodePayload decodes the payload following the command of a request,
+// it returns blockchain.ErrMalformedData when it is not the canonical
+// encoding of a message
+func decodePayload(request []byte, payload message) error {
+	if len(request) < commandLength {
+		return errors.New("request is truncated")
+	}
+	d := blockchain.NewDecoder(request[commandLength:])
+	payload.decode(d)
+
+	return d.Finish()
+}
+
+// NodeIsKnown checks whether addr is in the known nodes
+func NodeIsKnown(addr string) bool {
+	for _, node := range KnownNodes {
+		if node == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CloseDB closes the database when the process is interrupted
+func CloseDB(chain *blockchain.Blockchain) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	<-sigs
+
+	chainLock.Lock()
+	chain.DB.Close()
+	os.Exit(1)
+}
@@ -0,0 +1,125 @@
+package network
+
+import (
+	"encoding/hex"
+	"golang-blockchain/blockchain"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMalformedRequestsAreDropped(t *testing.T) {
+	garbage := append(CmdToBytes("x"), 0xff, 0x00, 0x13, 0x37)
+
+	handlers := map[string]func([]byte) error{
+		"addr":      HandleAddr,
+		"block":     func(req []byte) error { return HandleBlock(req, nil) },
+		"inv":       func(req []byte) error { return HandleInv(req, nil) },
+		"getblocks": func(req []byte) error { return HandleGetBlocks(req, nil) },
+		"getdata":   func(req []byte) error { return HandleGetData(req, nil) },
+		"tx":        func(req []byte) error { return HandleTx(req, nil) },
+		"version":   func(req []byte) error { return HandleVersion(req, nil) },
+	}
+	for command, handle := range handlers {
+		for _, req := range [][]byte{garbage, CmdToBytes(command), []byte("short")} {
+			if err := handle(req); err == nil {
+				t.Errorf("%s accepted malformed request %x", command, req)
+			}
+		}
+	}
+}
+
+func TestBadPayloadsAreDropped(t *testing.T) {
+	// an empty inventory of transactions has no item to ask for
+	req := append(CmdToBytes("inv"), encodePayload(&Inv{"localhost:3001", "tx", nil})...)
+	if err := HandleInv(req, nil); err != nil {
+		t.Errorf("empty inventory: %s", err)
+	}
+
+	req = append(CmdToBytes("block"), encodePayload(&Block{"localhost:3001", []byte{1, 2, 3}})...)
+	if err := HandleBlock(req, nil); err == nil {
+		t.Error("block that doesn't decode was accepted")
+	}
+
+	req = append(CmdToBytes("tx"), encodePayload(&Tx{"localhost:3001", []byte{1, 2, 3}})...)
+	if err := HandleTx(req, nil); err == nil {
+		t.Error("transaction that doesn't decode was accepted")
+	}
+}
+
+func TestSendDataReportsUnreachableNodes(t *testing.T) {
+	ln, err := net.Listen(protocol, "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	saved := KnownNodes
+	KnownNodes = []string{"localhost:3000", addr}
+	defer func() { KnownNodes = saved }()
+
+	if err := SendData(addr, CmdToBytes("getblocks")); err == nil {
+		t.Error("sending to a closed port succeeded")
+	}
+	if NodeIsKnown(addr) || !NodeIsKnown("localhost:3000") {
+		t.Errorf("known nodes are %v after %s was unreachable", KnownNodes, addr)
+	}
+}
+
+func TestOversizedRequestsAreDropped(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(server, nil)
+		close(done)
+	}()
+
+	// the request never ends, the handler stops reading after the limit
+	go client.Write(append(CmdToBytes("tx"), make([]byte, maxRequestSize)...))
+
+	select {
+	case <-done:
+	case <-time.After(readTimeout / 2):
+		t.Fatal("handler kept reading an oversized request")
+	}
+}
+
+func TestMessagesRoundTrip(t *testing.T) {
+	messages := map[string]message{
+		"addr":      &Addr{[]string{"localhost:3000", "localhost:3001"}},
+		"block":     &Block{"localhost:3001", []byte{1, 2, 3}},
+		"getblocks": &GetBlocks{"localhost:3001"},
+		"getdata":   &GetData{"localhost:3001", "tx", []byte{4, 5}},
+		"inv":       &Inv{"localhost:3001", "block", [][]byte{{1}, {2, 3}}},
+		"tx":        &Tx{"localhost:3001", []byte{6}},
+		"version":   &Version{version, 42, "localhost:3001"},
+	}
+	for command, msg := range messages {
+		req := append(CmdToBytes(command), encodePayload(msg)...)
+
+		decoded := reflect.New(reflect.TypeOf(msg).Elem()).Interface().(message)
+		if err := decodePayload(req, decoded); err != nil {
+			t.Errorf("%s: %s", command, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, msg) {
+			t.Errorf("%s decodes to %v, expected %v", command, decoded, msg)
+		}
+
+		if err := decodePayload(append(req, 0), decoded); err != blockchain.ErrMalformedData {
+			t.Errorf("%s with a trailing byte: got %v, expected ErrMalformedData", command, err)
+		}
+	}
+}
+
+func TestVersionEncoding(t *testing.T) {
+	got := hex.EncodeToString(encodePayload(&Version{2, 5, "localhost:3001"}))
+	want := "0000000000000002" + "0000000000000005" + "0000000e" + hex.EncodeToString([]byte("localhost:3001"))
+	if got != want {
+		t.Errorf("version message is encoded as %s, expected %s", got, want)
+	}
+}
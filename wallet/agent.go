@@ -0,0 +1,138 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The key of an encrypted wallet file can be kept for a while in the
+// memory of an agent process, so that the commands read the file without
+// asking for the passphrase. The agent listens on a Unix socket next to
+// the wallet file, which only the user can open, and sends the salt and
+// the key of the file to every connection. The key never goes to disk.
+
+const (
+	agentSocket = "wallets.agent"
+
+	// requests sent to the agent
+	agentGetKey = 'k'
+	agentLock   = 'l'
+
+	// agentTimeout bounds the time spent talking to an agent
+	agentTimeout = time.Second
+)
+
+// agentPath returns the socket of the agent of the wallet file in dataDir
+func agentPath(dataDir string) string {
+	return filepath.Join(dataDir, agentSocket)
+}
+
+// AgentKey returns what an agent needs to open the wallet file: the salt
+// and the key. It returns ErrWalletNotEncrypted for plaintext wallets
+func (ws *Wallets) AgentKey() ([]byte, error) {
+	if !ws.IsEncrypted() {
+		return nil, ErrWalletNotEncrypted
+	}
+
+	return append(append([]byte{}, ws.encryption.salt...), ws.encryption.key...), nil
+}
+
+// ServeAgent keeps the key returned by AgentKey for the commands reading
+// the wallet file in dataDir, until the timeout or Lock. ready is called
+// once they can connect
+func ServeAgent(dataDir string, key []byte, timeout time.Duration, ready func()) error {
+	if len(key) != saltLength+keyLength {
+		return errors.New("Wallet key is not valid")
+	}
+	if err := Lock(dataDir); err != nil {
+		return err
+	}
+
+	l, err := net.Listen("unix", agentPath(dataDir))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if err := os.Chmod(agentPath(dataDir), 0600); err != nil {
+		return err
+	}
+
+	timer := time.AfterFunc(timeout, func() { l.Close() })
+	defer timer.Stop()
+	ready()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			// closed by the timeout or a lock request
+			return nil
+		}
+
+		request := make([]byte, 1)
+		conn.SetDeadline(time.Now().Add(agentTimeout))
+		if _, err := io.ReadFull(conn, request); err == nil {
+			switch request[0] {
+			case agentGetKey:
+				conn.Write(key)
+			case agentLock:
+				l.Close()
+			}
+		}
+		conn.Close()
+	}
+}
+
+// Lock stops the agent of the wallet file in dataDir, the passphrase is
+// asked again. It does nothing when there is no agent
+func Lock(dataDir string) error {
+	conn, err := net.DialTimeout("unix", agentPath(dataDir), agentTimeout)
+	if err != nil {
+		// a socket left by an agent that didn't stop cleanly
+		if err := os.Remove(agentPath(dataDir)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	if _, err := conn.Write([]byte{agentLock}); err != nil {
+		return err
+	}
+	// the agent closes the connection once it stopped listening
+	_, err = conn.Read(make([]byte, 1))
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+// agentKey asks the agent of dataDir for the key of the wallet file with
+// the given salt, it returns nil when there is no agent for it
+func agentKey(dataDir string, salt []byte) []byte {
+	conn, err := net.DialTimeout("unix", agentPath(dataDir), agentTimeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	if _, err := conn.Write([]byte{agentGetKey}); err != nil {
+		return nil
+	}
+	content := make([]byte, saltLength+keyLength)
+	if _, err := io.ReadFull(conn, content); err != nil {
+		return nil
+	}
+	if bytes.Compare(content[:saltLength], salt) != 0 {
+		return nil
+	}
+
+	return content[saltLength:]
+}
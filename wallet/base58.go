@@ -23,12 +23,13 @@ func Base58Encode(input []byte) []byte {
 	}
 
 	ReverseBytes(result)
-	for b := range input {
-		if b == 0x00 {
-			result = append([]byte{b58Alphabet[0]}, result...)
-		} else {
+	// every leading zero byte is encoded as the first digit, the number
+	// drops them
+	for _, b := range input {
+		if b != 0x00 {
 			break
 		}
+		result = append([]byte{b58Alphabet[0]}, result...)
 	}
 
 	return result
@@ -39,10 +40,11 @@ func Base58Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
-			zeroBytes++
+	for _, b := range input {
+		if b != b58Alphabet[0] {
+			break
 		}
+		zeroBytes++
 	}
 
 	payload := input[zeroBytes:]
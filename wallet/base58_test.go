@@ -0,0 +1,91 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// base58Vectors are Bitcoin Core's Base58 test vectors
+var base58Vectors = []struct {
+	hex     string
+	encoded string
+}{
+	{"", ""},
+	{"61", "2g"},
+	{"626262", "a3gV"},
+	{"636363", "aPEr"},
+	{"73696d706c792061206c6f6e6720737472696e67", "2cFupjhnEsSn59qHXstmK2ffpLv2"},
+	{"00eb15231dfceb60925886b67d065299925915aeb172c06647", "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+	{"516b6fcd0f", "ABnLTmg"},
+	{"bf4f89001e670274dd", "3SEo3LWLoPntC"},
+	{"572e4794", "3EFU7m"},
+	{"ecac89cad93923c02321", "EJDM8drfXA6uyA"},
+	{"10c8511e", "Rt5zm"},
+	{"00000000000000000000", "1111111111"},
+}
+
+func TestBase58(t *testing.T) {
+	for _, v := range base58Vectors {
+		data, err := hex.DecodeString(v.hex)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if encoded := string(Base58Encode(data)); encoded != v.encoded {
+			t.Errorf("Base58Encode(%s) = %s, expected %s", v.hex, encoded, v.encoded)
+		}
+		if decoded := Base58Decode([]byte(v.encoded)); bytes.Compare(decoded, data) != 0 {
+			t.Errorf("Base58Decode(%s) = %x, expected %s", v.encoded, decoded, v.hex)
+		}
+	}
+}
+
+func TestAddressWithLeadingZeroHash(t *testing.T) {
+	// one in 256 public key hashes starts with a zero byte, which takes
+	// an extra 1 after the one of the version byte
+	for i := 0; i < 2000; i++ {
+		w, err := MakeWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		address := string(w.Address())
+		if !ValidateAddress(address) {
+			t.Fatalf("address %s of public key hash %x is not valid", address, PublicKeyHash(w.PublicKey))
+		}
+	}
+
+	hash := make([]byte, 20)
+	hash[19] = 1
+	payload := append([]byte{version}, hash...)
+	address := string(Base58Encode(append(payload, checksum(payload)...)))
+	if address[:20] != "11111111111111111111" || !ValidateAddress(address) {
+		t.Errorf("address %s of public key hash %x is not valid", address, hash)
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	w, err := MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := w.Address()
+	if !ValidateAddress(string(address)) {
+		t.Fatalf("address %s is not valid", address)
+	}
+
+	for i := range address {
+		tampered := append([]byte{}, address...)
+		tampered[i] = b58Alphabet[(bytes.IndexByte(b58Alphabet, tampered[i])+1)%len(b58Alphabet)]
+		if ValidateAddress(string(tampered)) {
+			t.Errorf("tampered address %s is valid", tampered)
+		}
+	}
+
+	if ValidateAddress(EncodePrivateKey(&w.PrivateKey)) {
+		t.Error("private key is taken for an address")
+	}
+	if ValidateAddress("") || ValidateAddress("1") {
+		t.Error("empty address is valid")
+	}
+}
@@ -0,0 +1,261 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// An encrypted wallet file holds the gob encoding of the wallets sealed
+// with AES-256-GCM, under a key derived from the passphrase with scrypt:
+//
+//	magic | uint32 N | uint32 r | uint32 p | salt | nonce | ciphertext
+//
+// The header is authenticated together with the ciphertext. Files that
+// don't start with the magic are the plain gob written by older versions.
+
+const (
+	encryptedMagic = "WALLETv2"
+
+	// scrypt cost parameters of new files, the ones of a file are read
+	// from its header
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// maxScryptN bounds the cost read from a file header
+	maxScryptN = 1 << 20
+
+	keyLength   = 32
+	saltLength  = 16
+	headerBytes = len(encryptedMagic) + 12 + saltLength
+)
+
+var (
+	// ErrWrongPassphrase is returned when the passphrase doesn't decrypt
+	// the wallet file
+	ErrWrongPassphrase = errors.New("Wrong wallet passphrase")
+	// ErrWalletEncrypted is returned when encrypting a wallet file twice
+	ErrWalletEncrypted = errors.New("Wallet file is already encrypted")
+	// ErrWalletNotEncrypted is returned when changing the passphrase of a
+	// plaintext wallet file
+	ErrWalletNotEncrypted = errors.New("Wallet file is not encrypted, run encryptwallet")
+)
+
+// PassphraseFunc asks for the passphrase of an encrypted wallet file
+type PassphraseFunc func() ([]byte, error)
+
+// encryption holds the key of an encrypted wallet file, so that it can be
+// saved again without asking for the passphrase
+type encryption struct {
+	n, r, p int
+	salt    []byte
+	key     []byte
+}
+
+// newEncryption derives a key from the passphrase with a new salt
+func newEncryption(passphrase []byte) (*encryption, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	enc := &encryption{n: scryptN, r: scryptR, p: scryptP, salt: salt}
+	return enc, enc.deriveKey(passphrase)
+}
+
+func (enc *encryption) deriveKey(passphrase []byte) error {
+	key, err := scrypt.Key(passphrase, enc.salt, enc.n, enc.r, enc.p, keyLength)
+	if err != nil {
+		return err
+	}
+	enc.key = key
+
+	return nil
+}
+
+func (enc *encryption) header() []byte {
+	var header bytes.Buffer
+	header.WriteString(encryptedMagic)
+	for _, v := range []int{enc.n, enc.r, enc.p} {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(v))
+		header.Write(b[:])
+	}
+	header.Write(enc.salt)
+
+	return header.Bytes()
+}
+
+func (enc *encryption) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(enc.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the content of a wallet file
+func (enc *encryption) seal(plaintext []byte) ([]byte, error) {
+	aead, err := enc.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header := enc.header()
+	sealed := append(header, nonce...)
+
+	return aead.Seal(sealed, nonce, plaintext, header), nil
+}
+
+// open decrypts the content of a wallet file with the key of enc, it
+// returns ErrWrongPassphrase when the key doesn't fit
+func (enc *encryption) open(data []byte) ([]byte, error) {
+	aead, err := enc.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerBytes+aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+
+	header := data[:headerBytes]
+	nonce := data[headerBytes : headerBytes+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[headerBytes+aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return plaintext, nil
+}
+
+// isEncrypted reports whether the content of a wallet file is encrypted
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedMagic))
+}
+
+// parseHeader reads the scrypt parameters and the salt of an encrypted
+// wallet file
+func parseHeader(data []byte) (*encryption, error) {
+	if len(data) < headerBytes {
+		return nil, errors.New("Wallet file is truncated")
+	}
+
+	params := data[len(encryptedMagic):]
+	enc := &encryption{
+		n:    int(binary.BigEndian.Uint32(params[0:])),
+		r:    int(binary.BigEndian.Uint32(params[4:])),
+		p:    int(binary.BigEndian.Uint32(params[8:])),
+		salt: append([]byte{}, params[12:12+saltLength]...),
+	}
+	if enc.n > maxScryptN || enc.r*enc.p >= 1<<20 {
+		return nil, errors.New("Wallet file has unsupported encryption parameters")
+	}
+
+	return enc, nil
+}
+
+// decrypt opens an encrypted wallet file, with the key kept by the agent
+// of dataDir when there is one and with the passphrase otherwise
+func decrypt(dataDir string, data []byte, passphrase PassphraseFunc) ([]byte, *encryption, error) {
+	enc, err := parseHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if key := agentKey(dataDir, enc.salt); key != nil {
+		enc.key = key
+		if plaintext, err := enc.open(data); err == nil {
+			return plaintext, enc, nil
+		}
+	}
+
+	secret, err := passphrase()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := enc.deriveKey(secret); err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := enc.open(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plaintext, enc, nil
+}
+
+// IsEncrypted reports whether the wallets are saved encrypted
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.encryption != nil
+}
+
+// Encrypt makes SaveToFile encrypt the wallets with a key derived from
+// the passphrase, it returns ErrWalletEncrypted when they already are
+func (ws *Wallets) Encrypt(passphrase []byte) error {
+	if ws.IsEncrypted() {
+		return ErrWalletEncrypted
+	}
+
+	enc, err := newEncryption(passphrase)
+	if err != nil {
+		return err
+	}
+	ws.encryption = enc
+
+	return nil
+}
+
+// ChangePassphrase makes SaveToFile encrypt the wallets with a key derived
+// from the new passphrase, it returns ErrWalletNotEncrypted for plaintext
+// wallets
+func (ws *Wallets) ChangePassphrase(passphrase []byte) error {
+	if !ws.IsEncrypted() {
+		return ErrWalletNotEncrypted
+	}
+
+	enc, err := newEncryption(passphrase)
+	if err != nil {
+		return err
+	}
+	ws.encryption = enc
+
+	return nil
+}
+
+// writeFile replaces a file with content readable by the user only. The
+// content is written to a temporary file first, so that a crash never
+// leaves a truncated wallet behind
+func writeFile(path string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
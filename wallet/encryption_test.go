@@ -0,0 +1,207 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// passphrase returns a PassphraseFunc giving s
+func passphrase(s string) PassphraseFunc {
+	return func() ([]byte, error) {
+		return []byte(s), nil
+	}
+}
+
+// noPassphrase fails the test when the passphrase is asked
+func noPassphrase(t *testing.T) PassphraseFunc {
+	return func() ([]byte, error) {
+		t.Error("passphrase was asked")
+		return nil, errors.New("no passphrase")
+	}
+}
+
+// newEncryptedWallet saves a wallet file with one address encrypted with
+// the passphrase "secret", it returns the data directory and the address
+func newEncryptedWallet(t *testing.T) (string, string) {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	ws, err := CreateWallets(dataDir, noPassphrase(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := ws.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Encrypt([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SaveToFile(dataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	return dataDir, address
+}
+
+// loadWallet loads the wallet file of dataDir and checks that it holds
+// address
+func loadWallet(t *testing.T, dataDir, address string, passphrase PassphraseFunc) *Wallets {
+	t.Helper()
+
+	ws, err := CreateWallets(dataDir, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.GetWallet(address); err != nil {
+		t.Fatalf("%s: %s", address, err)
+	}
+
+	return ws
+}
+
+func TestEncryptedWalletRoundTrip(t *testing.T) {
+	dataDir, address := newEncryptedWallet(t)
+
+	content, err := ioutil.ReadFile(walletPath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted(content) {
+		t.Fatal("wallet file is not encrypted")
+	}
+	if info, err := os.Stat(walletPath(dataDir)); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("wallet file mode is %v (%v)", info.Mode(), err)
+	}
+
+	ws := loadWallet(t, dataDir, address, passphrase("secret"))
+	if !ws.IsEncrypted() {
+		t.Error("loaded wallets are not encrypted")
+	}
+	if err := ws.Encrypt([]byte("again")); err != ErrWalletEncrypted {
+		t.Errorf("encrypting twice: %v", err)
+	}
+
+	// saving again keeps the file encrypted with the same passphrase
+	if err := ws.SaveToFile(dataDir); err != nil {
+		t.Fatal(err)
+	}
+	loadWallet(t, dataDir, address, passphrase("secret"))
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	dataDir, _ := newEncryptedWallet(t)
+
+	for _, wrong := range []string{"", "Secret", "secret "} {
+		if _, err := CreateWallets(dataDir, passphrase(wrong)); err != ErrWrongPassphrase {
+			t.Errorf("passphrase %q: got %v, expected ErrWrongPassphrase", wrong, err)
+		}
+	}
+}
+
+func TestTamperedWalletFile(t *testing.T) {
+	dataDir, _ := newEncryptedWallet(t)
+	content, err := ioutil.ReadFile(walletPath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := map[string]int{
+		"scrypt r":       len(encryptedMagic) + 7,
+		"salt":           len(encryptedMagic) + 12,
+		"nonce":          headerBytes,
+		"ciphertext":     headerBytes + 20,
+		"authentication": len(content) - 1,
+	}
+	for name, offset := range tampered {
+		changed := append([]byte{}, content...)
+		changed[offset] ^= 1
+		if err := ioutil.WriteFile(walletPath(dataDir), changed, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := CreateWallets(dataDir, passphrase("secret")); err != ErrWrongPassphrase {
+			t.Errorf("tampered %s: got %v, expected ErrWrongPassphrase", name, err)
+		}
+	}
+
+	// costs beyond the bounds are refused before deriving the key
+	changed := append([]byte{}, content...)
+	changed[len(encryptedMagic)] = 0xff
+	if err := ioutil.WriteFile(walletPath(dataDir), changed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateWallets(dataDir, noPassphrase(t)); err == nil {
+		t.Error("wallet file with a scrypt N above the bound was opened")
+	}
+
+	if err := ioutil.WriteFile(walletPath(dataDir), content[:headerBytes-1], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateWallets(dataDir, noPassphrase(t)); err == nil {
+		t.Error("truncated wallet file was opened")
+	}
+}
+
+func TestChangePassphrase(t *testing.T) {
+	dataDir, address := newEncryptedWallet(t)
+
+	ws := loadWallet(t, dataDir, address, passphrase("secret"))
+	if err := ws.ChangePassphrase([]byte("new secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SaveToFile(dataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateWallets(dataDir, passphrase("secret")); err != ErrWrongPassphrase {
+		t.Errorf("old passphrase: got %v, expected ErrWrongPassphrase", err)
+	}
+	loadWallet(t, dataDir, address, passphrase("new secret"))
+
+	plain := Wallets{Wallets: make(map[string]*Wallet)}
+	if err := plain.ChangePassphrase([]byte("secret")); err != ErrWalletNotEncrypted {
+		t.Errorf("plaintext wallets: got %v, expected ErrWalletNotEncrypted", err)
+	}
+}
+
+func TestAgentKeepsTheKey(t *testing.T) {
+	dataDir, address := newEncryptedWallet(t)
+
+	ws := loadWallet(t, dataDir, address, passphrase("secret"))
+	key, err := ws.AgentKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ready := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- ServeAgent(dataDir, key, time.Minute, func() { close(ready) })
+	}()
+	select {
+	case <-ready:
+	case err := <-done:
+		t.Fatal(err)
+	}
+
+	loadWallet(t, dataDir, address, noPassphrase(t))
+
+	if err := Lock(dataDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(agentPath(dataDir)); !os.IsNotExist(err) {
+		t.Errorf("agent socket is left behind: %v", err)
+	}
+	loadWallet(t, dataDir, address, passphrase("secret"))
+
+	plain := Wallets{Wallets: make(map[string]*Wallet)}
+	if _, err := plain.AgentKey(); err != ErrWalletNotEncrypted {
+		t.Errorf("plaintext wallets: got %v, expected ErrWalletNotEncrypted", err)
+	}
+}
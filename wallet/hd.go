@@ -0,0 +1,207 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// The addresses of a wallet are derived from a single seed following
+// SLIP-0010, the variant of BIP32 for the P-256 curve used by every key of
+// the wallet. The seed is computed from a BIP39 mnemonic phrase, which is
+// all that is needed to restore the addresses, and address i has the key
+// at path m/0'/0/i. Where BIP32 skips an index that gives no valid key,
+// SLIP-0010 hashes again until it gets one, so every index has a key.
+
+const (
+	// hardened is added to the index of hardened children
+	hardened uint32 = 0x80000000
+	// mnemonicBits is the entropy of new mnemonic phrases, 12 words
+	mnemonicBits = 128
+	// DefaultGapLimit is the number of unused addresses in a row after
+	// which scanning for the used addresses of a restored wallet stops
+	DefaultGapLimit = 20
+)
+
+var (
+	// masterHMACKey keys the derivation of the master key from the seed,
+	// SLIP-0010 names the P-256 curve NIST P-256 or nist256p1
+	masterHMACKey = []byte("Nist256p1 seed")
+
+	// ErrInvalidMnemonic is returned for phrases with unknown words or a
+	// bad checksum
+	ErrInvalidMnemonic = errors.New("Mnemonic phrase is not valid")
+	// ErrWalletExists is returned when restoring into a wallet file that
+	// already holds keys
+	ErrWalletExists = errors.New("Wallet file already holds keys")
+)
+
+// extendedKey is a private key with the chain code its children are
+// derived with
+type extendedKey struct {
+	key       *big.Int
+	chainCode []byte
+}
+
+// hmacSHA512 returns the two halves of HMAC-SHA512 of data
+func hmacSHA512(key, data []byte) ([]byte, []byte) {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	return sum[:32], sum[32:]
+}
+
+// masterKey derives the root key of a seed. When the left half of the
+// hash is not a valid key, the whole hash is hashed again
+func masterKey(seed []byte) *extendedKey {
+	n := elliptic.P256().Params().N
+
+	data := seed
+	for {
+		il, ir := hmacSHA512(masterHMACKey, data)
+
+		key := new(big.Int).SetBytes(il)
+		if key.Sign() != 0 && key.Cmp(n) < 0 {
+			return &extendedKey{key, ir}
+		}
+		data = append(append([]byte{}, il...), ir...)
+	}
+}
+
+// child derives the child key with the given index, hardened children
+// can't be derived from the public key of the parent. When the hash gives
+// no valid key, 0x01, its right half and the index are hashed instead
+func (k *extendedKey) child(index uint32) *extendedKey {
+	curve := elliptic.P256()
+	n := curve.Params().N
+
+	var data []byte
+	if index >= hardened {
+		data = append([]byte{0}, k.keyBytes()...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.keyBytes())
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	var i [4]byte
+	binary.BigEndian.PutUint32(i[:], index)
+
+	for {
+		il, ir := hmacSHA512(k.chainCode, append(data, i[:]...))
+
+		tweak := new(big.Int).SetBytes(il)
+		if tweak.Cmp(n) < 0 {
+			key := tweak.Add(tweak, k.key)
+			key.Mod(key, n)
+			if key.Sign() != 0 {
+				return &extendedKey{key, ir}
+			}
+		}
+		data = append([]byte{1}, ir...)
+	}
+}
+
+// keyBytes returns the private key padded to 32 bytes
+func (k *extendedKey) keyBytes() []byte {
+	b := make([]byte, 32)
+	d := k.key.Bytes()
+	copy(b[32-len(d):], d)
+
+	return b
+}
+
+func (k *extendedKey) wallet() *Wallet {
+	return walletFromKey(k.key)
+}
+
+// NewMnemonic returns a new random mnemonic phrase
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(mnemonicBits)
+	if err != nil {
+		return "", err
+	}
+
+	return bip39.NewMnemonic(entropy)
+}
+
+// deriveWallet returns the wallet of the address with the given index
+func (ws *Wallets) deriveWallet(index uint32) (*Wallet, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(ws.Mnemonic, "")
+	if err != nil {
+		return nil, ErrInvalidMnemonic
+	}
+
+	key := masterKey(seed)
+	for _, i := range []uint32{hardened + 0, 0, index} {
+		key = key.child(i)
+	}
+
+	return key.wallet(), nil
+}
+
+// nextWallet derives the wallet of the next address
+func (ws *Wallets) nextWallet() (*Wallet, error) {
+	w, err := ws.deriveWallet(ws.NextIndex)
+	if err != nil {
+		return nil, err
+	}
+	ws.NextIndex++
+
+	return w, nil
+}
+
+// Restore fills empty Wallets with the addresses derived from a mnemonic
+// phrase. Addresses are derived until gapLimit of them in a row are not
+// used, and every address up to the last used one is kept, at least the
+// first one. It returns ErrInvalidMnemonic for a bad phrase and
+// ErrWalletExists when the wallets already hold keys
+func (ws *Wallets) Restore(mnemonic string, gapLimit int, used func(pubKeyHash []byte) (bool, error)) error {
+	if len(ws.Wallets) > 0 || ws.Mnemonic != "" {
+		return ErrWalletExists
+	}
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return ErrInvalidMnemonic
+	}
+	ws.Mnemonic = mnemonic
+
+	if gapLimit < 1 {
+		gapLimit = 1
+	}
+
+	// next holds NextIndex after each derived address, and last the
+	// number of addresses up to the last used one
+	var derived []*Wallet
+	var next []uint32
+	last := 0
+	for len(derived)-last < gapLimit {
+		w, err := ws.nextWallet()
+		if err != nil {
+			return err
+		}
+		derived = append(derived, w)
+		next = append(next, ws.NextIndex)
+
+		isUsed, err := used(PublicKeyHash(w.PublicKey))
+		if err != nil {
+			return err
+		}
+		if isUsed {
+			last = len(derived)
+		}
+	}
+	if last == 0 {
+		last = 1
+	}
+
+	for _, w := range derived[:last] {
+		ws.Wallets[string(w.Address())] = w
+	}
+	ws.NextIndex = next[last-1]
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// derivation is an extended key expected at the end of a path
+type derivation struct {
+	path      []uint32
+	chainCode string
+	key       string
+}
+
+// checkDerivations derives the paths of the SLIP-0010 test vectors from a
+// hex seed
+func checkDerivations(t *testing.T, seedHex string, derivations []derivation) {
+	t.Helper()
+
+	seed, err := hex.DecodeString(seedHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range derivations {
+		key := masterKey(seed)
+		for _, i := range d.path {
+			key = key.child(i)
+		}
+
+		if chainCode := hex.EncodeToString(key.chainCode); chainCode != d.chainCode {
+			t.Errorf("path %v: chain code is %s, expected %s", d.path, chainCode, d.chainCode)
+		}
+		if private := hex.EncodeToString(key.keyBytes()); private != d.key {
+			t.Errorf("path %v: private key is %s, expected %s", d.path, private, d.key)
+		}
+	}
+}
+
+func TestSLIP10Vector1(t *testing.T) {
+	checkDerivations(t, "000102030405060708090a0b0c0d0e0f", []derivation{
+		{nil,
+			"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+		{[]uint32{hardened + 0},
+			"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+		{[]uint32{hardened + 0, 1},
+			"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+			"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129"},
+		{[]uint32{hardened + 0, 1, hardened + 2},
+			"98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318",
+			"694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7"},
+		{[]uint32{hardened + 0, 1, hardened + 2, 2},
+			"ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0",
+			"5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa"},
+		{[]uint32{hardened + 0, 1, hardened + 2, 2, 1000000000},
+			"b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059",
+			"21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119"},
+	})
+}
+
+func TestSLIP10DerivationRetry(t *testing.T) {
+	// the child at index 33941 of m/28578' gives a key out of range
+	checkDerivations(t, "000102030405060708090a0b0c0d0e0f", []derivation{
+		{[]uint32{hardened + 28578},
+			"e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2",
+			"06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669"},
+		{[]uint32{hardened + 28578, 33941},
+			"9e87fe95031f14736774cd82f25fd885065cb7c358c1edf813c72af535e83071",
+			"092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a"},
+	})
+}
+
+func TestSLIP10SeedRetry(t *testing.T) {
+	// the first hash of this seed gives a master key out of range
+	checkDerivations(t, "a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", []derivation{
+		{nil,
+			"7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c",
+			"3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f"},
+	})
+}
+
+func TestRestoreDerivesTheSameAddresses(t *testing.T) {
+	original := Wallets{Wallets: make(map[string]*Wallet)}
+	var addresses []string
+	for i := 0; i < 3; i++ {
+		address, err := original.AddWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, address)
+	}
+
+	// the second address is the last one used
+	used := func(pubKeyHash []byte) (bool, error) {
+		return bytes.Compare(pubKeyHash, PublicKeyHash(original.Wallets[addresses[1]].PublicKey)) == 0, nil
+	}
+	restored := Wallets{Wallets: make(map[string]*Wallet)}
+	if err := restored.Restore(original.Mnemonic, 2, used); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored.Wallets) != 2 || restored.NextIndex != 2 {
+		t.Fatalf("restored %d addresses up to index %d, expected 2 and 2", len(restored.Wallets), restored.NextIndex)
+	}
+	for _, address := range addresses[:2] {
+		if _, ok := restored.Wallets[address]; !ok {
+			t.Errorf("address %s was not restored", address)
+		}
+	}
+
+	if err := restored.Restore(original.Mnemonic, 2, used); err != ErrWalletExists {
+		t.Errorf("restoring twice: %v", err)
+	}
+	fresh := Wallets{Wallets: make(map[string]*Wallet)}
+	if err := fresh.Restore("abandon abandon abandon", 2, used); err != ErrInvalidMnemonic {
+		t.Errorf("invalid mnemonic: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+)
+
+// Private keys are exported the way Bitcoin's WIF does, as the Base58 of
+//
+//	0xb4 | 32-byte private key | checksum
+//
+// except that the key is a P-256 one, like every key of the wallet. The
+// version byte differs from WIF's 0x80, so that Bitcoin tools don't take
+// the key for one of theirs, and encoded keys start with a 7.
+
+const privateKeyVersion = byte(0xb4)
+
+// ErrInvalidPrivateKey is returned for encoded private keys with a bad
+// checksum, version or length, or that are out of the range of the curve
+var ErrInvalidPrivateKey = errors.New("Private key is not valid")
+
+// EncodePrivateKey returns the Base58Check encoding of a private key
+func EncodePrivateKey(key *ecdsa.PrivateKey) string {
+	d := key.D.Bytes()
+	payload := make([]byte, 1+32)
+	payload[0] = privateKeyVersion
+	copy(payload[1+32-len(d):], d)
+
+	return string(Base58Encode(append(payload, checksum(payload)...)))
+}
+
+// DecodePrivateKey returns the wallet of an encoded private key, or
+// ErrInvalidPrivateKey
+func DecodePrivateKey(encoded string) (*Wallet, error) {
+	data := Base58Decode([]byte(encoded))
+	if len(data) != 1+32+checksumLength || data[0] != privateKeyVersion {
+		return nil, ErrInvalidPrivateKey
+	}
+	payload := data[:1+32]
+	if bytes.Compare(data[1+32:], checksum(payload)) != 0 {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	d := new(big.Int).SetBytes(payload[1:])
+	if d.Sign() == 0 || d.Cmp(elliptic.P256().Params().N) >= 0 {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	return walletFromKey(d), nil
+}
+
+// DumpPrivKey returns the encoded private key of an address of the wallet
+func (ws *Wallets) DumpPrivKey(address string) (string, error) {
+	wallet, err := ws.GetWallet(address)
+	if err != nil {
+		return "", err
+	}
+
+	return EncodePrivateKey(&wallet.PrivateKey), nil
+}
+
+// ImportPrivKey adds the wallet of an encoded private key and returns its
+// address, a watch-only address becomes spendable. The key is not derived
+// from the mnemonic phrase, restoring the wallet doesn't bring it back. It
+// returns ErrAddressInWallet when the wallet already holds the key
+func (ws *Wallets) ImportPrivKey(encoded string) (string, error) {
+	wallet, err := DecodePrivateKey(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	address := string(wallet.Address())
+	if _, ok := ws.Wallets[address]; ok {
+		return "", ErrAddressInWallet
+	}
+	delete(ws.WatchOnly, address)
+	ws.Wallets[address] = wallet
+
+	return address, nil
+}
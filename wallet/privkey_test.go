@@ -0,0 +1,105 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// encodeKeyBytes encodes a private key given as 32 bytes, without checking
+// that it is in range
+func encodeKeyBytes(version byte, d []byte) string {
+	payload := append([]byte{version}, d...)
+
+	return string(Base58Encode(append(payload, checksum(payload)...)))
+}
+
+func TestPrivateKeyRoundTrip(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		w, err := MakeWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encoded := EncodePrivateKey(&w.PrivateKey)
+		if !strings.HasPrefix(encoded, "7") {
+			t.Errorf("encoded private key %s doesn't start with 7", encoded)
+		}
+		decoded, err := DecodePrivateKey(encoded)
+		if err != nil {
+			t.Fatalf("%s: %s", encoded, err)
+		}
+		if decoded.PrivateKey.D.Cmp(w.PrivateKey.D) != 0 || string(decoded.Address()) != string(w.Address()) {
+			t.Fatalf("%s decodes to another key", encoded)
+		}
+	}
+
+	// small keys are padded to 32 bytes
+	small := walletFromKey(big.NewInt(1))
+	decoded, err := DecodePrivateKey(EncodePrivateKey(&small.PrivateKey))
+	if err != nil || decoded.PrivateKey.D.Int64() != 1 {
+		t.Errorf("key 1 doesn't round-trip: %v", err)
+	}
+}
+
+func TestInvalidPrivateKeys(t *testing.T) {
+	w, err := MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := EncodePrivateKey(&w.PrivateKey)
+	d := make([]byte, 32)
+	w.PrivateKey.D.FillBytes(d)
+
+	invalid := map[string]string{
+		"empty":           "",
+		"address":         string(w.Address()),
+		"truncated":       encoded[:len(encoded)-1],
+		"extra character": encoded + "1",
+		"WIF version":     encodeKeyBytes(0x80, d),
+		"zero key":        encodeKeyBytes(privateKeyVersion, make([]byte, 32)),
+		"key of order N":  encodeKeyBytes(privateKeyVersion, elliptic.P256().Params().N.Bytes()),
+	}
+	// every changed character breaks the checksum
+	for i := range encoded {
+		tampered := []byte(encoded)
+		tampered[i] = b58Alphabet[(strings.IndexByte(string(b58Alphabet), tampered[i])+1)%len(b58Alphabet)]
+		invalid[fmt.Sprintf("tampered character %d", i)] = string(tampered)
+	}
+
+	for name, key := range invalid {
+		if _, err := DecodePrivateKey(key); err != ErrInvalidPrivateKey {
+			t.Errorf("%s %q: got %v, expected ErrInvalidPrivateKey", name, key, err)
+		}
+	}
+}
+
+func TestImportPrivKey(t *testing.T) {
+	w, err := MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.Address())
+
+	ws := Wallets{Wallets: make(map[string]*Wallet)}
+	if err := ws.AddWatchOnly(address, w.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := ws.ImportPrivKey(EncodePrivateKey(&w.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != address || ws.IsWatchOnly(address) {
+		t.Errorf("imported %s, watch-only %v", imported, ws.IsWatchOnly(address))
+	}
+	if dumped, err := ws.DumpPrivKey(address); err != nil || dumped != EncodePrivateKey(&w.PrivateKey) {
+		t.Errorf("dumped %s: %v", dumped, err)
+	}
+
+	if _, err := ws.ImportPrivKey(EncodePrivateKey(&w.PrivateKey)); err != ErrAddressInWallet {
+		t.Errorf("importing twice: %v", err)
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"log"
+	"math/big"
 
 	"golang.org/x/crypto/ripemd160"
 )
@@ -35,8 +35,13 @@ func (w Wallet) Address() []byte {
 	return address
 }
 
+// ValidateAddress checks the version, the length and the checksum of an
+// address
 func ValidateAddress(address string) bool {
 	pubKeyHash := Base58Decode([]byte(address))
+	if len(pubKeyHash) != 1+ripemd160.Size+checksumLength || pubKeyHash[0] != version {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
@@ -46,34 +51,56 @@ func ValidateAddress(address string) bool {
 }
 
 // NewKeyPair returns a new private and public key
-func newKeyPair() (ecdsa.PrivateKey, []byte) {
+func newKeyPair() (ecdsa.PrivateKey, []byte, error) {
 	curve := elliptic.P256()
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
-		log.Panic(err)
+		return ecdsa.PrivateKey{}, nil, err
 	}
 
-	public := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	return *private, PublicKeyBytes(&private.PublicKey), nil
+}
+
+// PublicKeyBytes encodes a public key as its X and Y coordinates, each
+// padded to 32 bytes so that the encoding splits in the middle
+func PublicKeyBytes(pub *ecdsa.PublicKey) []byte {
+	public := make([]byte, 64)
+	x, y := pub.X.Bytes(), pub.Y.Bytes()
+	copy(public[32-len(x):32], x)
+	copy(public[64-len(y):], y)
+
+	return public
+}
+
+// walletFromKey returns the wallet of a private key of the curve
+func walletFromKey(d *big.Int) *Wallet {
+	curve := elliptic.P256()
+
+	private := ecdsa.PrivateKey{D: new(big.Int).Set(d)}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
 
-	return *private, public
+	return &Wallet{private, PublicKeyBytes(&private.PublicKey)}
 }
 
-// MakeWallet creates and returns a Wallet
-func MakeWallet() *Wallet {
-	private, public := newKeyPair()
+// MakeWallet creates and returns a Wallet with a random key, which is not
+// derived from any seed
+func MakeWallet() (*Wallet, error) {
+	private, public, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
 	wallet := &Wallet{private, public}
-	return wallet
+	return wallet, nil
 }
 
 // PublicKeyHash hashes public key
 func PublicKeyHash(pubKey []byte) []byte {
 	pubHash := sha256.Sum256(pubKey)
 
+	// writing to a hash never fails
 	hasher := ripemd160.New()
-	_, err := hasher.Write(pubHash[:])
-	if err != nil {
-		log.Panic(err)
-	}
+	hasher.Write(pubHash[:])
 	publicRipMD := hasher.Sum(nil)
 
 	return publicRipMD
@@ -4,37 +4,74 @@ import (
 	"bytes"
 	"crypto/elliptic"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
+	"path/filepath"
 )
 
-const walletFile = "./tmp/wallets.data"
+const walletFile = "wallets.data"
+
+// ErrWalletNotFound is returned when the wallet file holds no key for an address
+var ErrWalletNotFound = errors.New("Wallet not found")
+
+// walletPath returns the wallet file inside a data directory
+func walletPath(dataDir string) string {
+	return filepath.Join(dataDir, walletFile)
+}
 
 // Wallets stores a collection of wallets
 type Wallets struct {
 	Wallets map[string]*Wallet
+	// Mnemonic is the phrase of the seed the addresses are derived from,
+	// it is empty until the first address of a wallet file is derived
+	Mnemonic string
+	// NextIndex is the index of the next derived address
+	NextIndex uint32
+	// WatchOnly maps the addresses watched without their key to their
+	// public key, nil when it is not known
+	WatchOnly map[string][]byte
+
+	// encryption is nil when the wallet file is not encrypted
+	encryption *encryption
 }
 
-// CreateWallets creates Wallets and fills it from a file if it exists
-func CreateWallets() (*Wallets, error) {
+// CreateWallets creates Wallets and fills it from the file in dataDir if
+// it exists. passphrase is only called when the file is encrypted and
+// not unlocked
+func CreateWallets(dataDir string, passphrase PassphraseFunc) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
 
-	err := wallets.LoadFromFile()
+	err := wallets.LoadFromFile(dataDir, passphrase)
+	if os.IsNotExist(err) {
+		return &wallets, nil
+	}
 
 	return &wallets, err
 }
 
-// AddWallet adds a Wallet to Wallets
-func (ws *Wallets) AddWallet() string {
-	wallet := MakeWallet()
+// AddWallet derives the next address of the seed and adds its Wallet to
+// Wallets. A new mnemonic phrase is made for the first address
+func (ws *Wallets) AddWallet() (string, error) {
+	if ws.Mnemonic == "" {
+		mnemonic, err := NewMnemonic()
+		if err != nil {
+			return "", err
+		}
+		ws.Mnemonic = mnemonic
+	}
+
+	wallet, err := ws.nextWallet()
+	if err != nil {
+		return "", err
+	}
 	address := fmt.Sprintf("%s", wallet.Address())
 
 	ws.Wallets[address] = wallet
 
-	return address
+	return address, nil
 }
 
 // GetAllAddresses returns an array of addresses stored in the wallet file
@@ -48,13 +85,25 @@ func (ws *Wallets) GetAllAddresses() []string {
 	return addresses
 }
 
-// GetWallet returns a Wallet by its address
-func (ws Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
+// GetWallet returns a Wallet by its address, or ErrWatchOnly for a
+// watch-only address and ErrWalletNotFound for an unknown one
+func (ws Wallets) GetWallet(address string) (Wallet, error) {
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		if ws.IsWatchOnly(address) {
+			return Wallet{}, ErrWatchOnly
+		}
+		return Wallet{}, ErrWalletNotFound
+	}
+
+	return *wallet, nil
 }
 
-// LoadFromFile loads wallets from the file
-func (ws *Wallets) LoadFromFile() error {
+// LoadFromFile loads wallets from the file in dataDir, decrypting it
+// with the key kept by its agent or the passphrase when it is encrypted. It
+// returns ErrWrongPassphrase when the passphrase doesn't fit
+func (ws *Wallets) LoadFromFile(dataDir string, passphrase PassphraseFunc) error {
+	walletFile := walletPath(dataDir)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -66,6 +115,14 @@ func (ws *Wallets) LoadFromFile() error {
 		return err
 	}
 
+	var enc *encryption
+	if isEncrypted(fileContent) {
+		fileContent, enc, err = decrypt(dataDir, fileContent, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
 	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
 	err = decoder.Decode(&wallets)
@@ -73,25 +130,66 @@ func (ws *Wallets) LoadFromFile() error {
 		return err
 	}
 
-	ws.Wallets = wallets.Wallets
+	ws.setAddresses(wallets.Wallets, wallets.WatchOnly)
+	ws.Mnemonic = wallets.Mnemonic
+	ws.NextIndex = wallets.NextIndex
+	ws.encryption = enc
 
 	return nil
 }
 
-// SaveToFile saves wallets to a file
-func (ws *Wallets) SaveToFile() {
+// setAddresses keys the wallets and the watch-only public keys of a
+// wallet file by their address again, as older versions encoded the
+// addresses whose public key hash starts with a zero byte wrongly. The
+// watch-only addresses without a public key are kept as they were given
+func (ws *Wallets) setAddresses(wallets map[string]*Wallet, watchOnly map[string][]byte) {
+	ws.Wallets = make(map[string]*Wallet)
+	for _, wallet := range wallets {
+		ws.Wallets[string(wallet.Address())] = wallet
+	}
+
+	ws.WatchOnly = nil
+	for address, pubKey := range watchOnly {
+		if pubKey != nil {
+			address = AddressOf(pubKey)
+		}
+		if _, ok := ws.Wallets[address]; ok {
+			continue
+		}
+		if ws.WatchOnly == nil {
+			ws.WatchOnly = make(map[string][]byte)
+		}
+		ws.WatchOnly[address] = pubKey
+	}
+}
+
+// SaveToFile saves wallets to the file in dataDir, creating the directory
+// when needed. The file is encrypted when the wallets were loaded from an
+// encrypted file or Encrypt was called, and only the user can read it
+func (ws *Wallets) SaveToFile(dataDir string) error {
 	var content bytes.Buffer
+	walletFile := walletPath(dataDir)
 
 	gob.Register(elliptic.P256())
 
 	encoder := gob.NewEncoder(&content)
 	err := encoder.Encode(ws)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+
+	data := content.Bytes()
+	if ws.encryption != nil {
+		data, err = ws.encryption.seal(data)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	err = os.MkdirAll(dataDir, 0755)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+
+	return writeFile(walletFile, data)
 }
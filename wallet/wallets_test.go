@@ -0,0 +1,43 @@
+package wallet
+
+import (
+	"testing"
+)
+
+func TestSetAddressesRekeysWallets(t *testing.T) {
+	owned, err := MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	watched, err := MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := MakeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys as a file written by an older version may hold them, under
+	// wrongly encoded addresses
+	ws := Wallets{}
+	ws.setAddresses(
+		map[string]*Wallet{"wrong owned": owned},
+		map[string][]byte{
+			"wrong watched":  watched.PublicKey,
+			"no public key":  nil,
+			"wrong imported": imported.PublicKey,
+		},
+	)
+	ws.setAddresses(
+		map[string]*Wallet{"wrong owned": owned, "wrong imported": imported},
+		ws.WatchOnly,
+	)
+
+	if len(ws.Wallets) != 2 || ws.Wallets[string(owned.Address())] != owned || ws.Wallets[string(imported.Address())] != imported {
+		t.Errorf("wallets are keyed by %v", ws.GetAllAddresses())
+	}
+	if len(ws.WatchOnly) != 2 || !ws.IsWatchOnly(string(watched.Address())) || !ws.IsWatchOnly("no public key") {
+		t.Errorf("watch-only addresses are %v", ws.GetWatchOnlyAddresses())
+	}
+}
@@ -0,0 +1,77 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+)
+
+var (
+	// ErrWatchOnly is returned when asking for the key of an address the
+	// wallet only watches
+	ErrWatchOnly = errors.New("Address is watch-only, the wallet can't sign for it")
+	// ErrAddressInWallet is returned when watching an address the wallet
+	// holds the key of
+	ErrAddressInWallet = errors.New("Address is already in the wallet")
+	// ErrInvalidPublicKey is returned for public keys that are not points
+	// of the curve
+	ErrInvalidPublicKey = errors.New("Public key is not valid")
+)
+
+// ValidatePublicKey checks that a public key, its X and Y coordinates, is
+// a point of the curve
+func ValidatePublicKey(pubKey []byte) bool {
+	if len(pubKey) != 64 {
+		return false
+	}
+	x := new(big.Int).SetBytes(pubKey[:32])
+	y := new(big.Int).SetBytes(pubKey[32:])
+
+	return elliptic.P256().IsOnCurve(x, y)
+}
+
+// AddressOf returns the address of a public key
+func AddressOf(pubKey []byte) string {
+	return string(Wallet{PublicKey: pubKey}.Address())
+}
+
+// AddWatchOnly adds an address the wallet doesn't hold the key of, so
+// that its balance and history can be followed. The public key is kept
+// when known, it may be nil. Watching an address again records its
+// public key
+func (ws *Wallets) AddWatchOnly(address string, pubKey []byte) error {
+	if _, ok := ws.Wallets[address]; ok {
+		return ErrAddressInWallet
+	}
+	if pubKey != nil && AddressOf(pubKey) != address {
+		return ErrInvalidPublicKey
+	}
+
+	if ws.WatchOnly == nil {
+		ws.WatchOnly = make(map[string][]byte)
+	}
+	if known := ws.WatchOnly[address]; pubKey == nil && known != nil {
+		return nil
+	}
+	ws.WatchOnly[address] = pubKey
+
+	return nil
+}
+
+// IsWatchOnly reports whether the wallet watches the address without
+// holding its key
+func (ws *Wallets) IsWatchOnly(address string) bool {
+	_, ok := ws.WatchOnly[address]
+	return ok
+}
+
+// GetWatchOnlyAddresses returns the watch-only addresses
+func (ws *Wallets) GetWatchOnlyAddresses() []string {
+	var addresses []string
+
+	for address := range ws.WatchOnly {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}